@@ -44,16 +44,18 @@ func watchAlerts(rc config.RuntimeConfig, alertConfig config.AlertConfig) {
 	default:
 		panic("No such backend")
 	}
-	query := querySelectorsToQuery(&alertConfig.Selector)
+	env := rc.Config.Environments[alertConfig.Env]
+	selector := withEnvDefaults(env, &alertConfig.Selector)
+	query := querySelectorsToQuery(selector)
 	query.Follow = true
 	query.MaxResults = 100
-	client := determineClient(rc.Config.Environments[alertConfig.Env])
+	client := determineClient(env)
 	if client == nil {
 		fmt.Println("Cannot obtain a client for", alertConfig)
 		return
 	}
 	fmt.Println("Now waiting for alerts for", alertConfig.Name)
-	for message := range client.Query(query) {
+	for message := range queryMessages(rc, env, client, query, selector) {
 		fmt.Printf("[%s] Sending alert to %s: %+v\n", alertConfig.Name, alertConfig.Service["backend"], message.Map())
 		err := alerter.SendAlert(message)
 		if err != nil {
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/dedup"
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh/terminal"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// How often summaries of repeated messages are printed when following to a non-terminal
+const dedupSummaryInterval = 10 * time.Second
+
+// Number of groups remembered when following, beyond this the least recently
+// repeated ones are forgotten (groups outside the window are forgotten anyway)
+const dedupMaxGroups = 10000
+
+func formatGroup(group *dedup.Group, highlight bool) string {
+	counter := fmt.Sprintf("%d×", group.Count)
+	if highlight {
+		counter = color.New(color.FgYellow, color.Bold).Sprint(counter)
+	}
+	seen := ""
+	if group.Count > 1 {
+		seen = fmt.Sprintf("(first %s, last %s)", group.FirstSeen.Format(common.TimeFormat), group.LastSeen.Format(common.TimeFormat))
+	}
+	return fmt.Sprintf("%s %s%s", counter, formatText(group.Message, highlight), seen)
+}
+
+func printGroup(group *dedup.Group, queryOutputFormat string) {
+	switch queryOutputFormat {
+	case "text":
+		fmt.Println(formatGroup(group, true))
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		err := encoder.Encode(group.Map())
+		if err != nil {
+			fmt.Println("Error JSON encoding")
+		}
+	case "pretty-json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(group.Map())
+		if err != nil {
+			fmt.Println("Error JSON encoding")
+		}
+	case "yaml":
+		buf, err := yaml.Marshal(group.Map())
+		if err != nil {
+			fmt.Println("Error YAML encoding")
+		}
+		fmt.Printf("---\n%s", string(buf))
+	}
+}
+
+// Prints every group once all messages have been read
+func printDeduplicated(d *dedup.Deduplicator, messages <-chan common.LogMessage, queryOutputFormat string) {
+	for message := range messages {
+		d.Add(message)
+	}
+	for _, group := range d.Groups() {
+		printGroup(group, queryOutputFormat)
+	}
+}
+
+// Prints new groups right away, and periodically prints summaries of groups
+// that were repeated since. Groups that are forgotten get a final summary.
+func followDeduplicated(d *dedup.Deduplicator, messages <-chan common.LogMessage, queryOutputFormat string) {
+	ticker := time.NewTicker(dedupSummaryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				for _, group := range d.Changed() {
+					printGroup(group, queryOutputFormat)
+				}
+				return
+			}
+			for _, group := range d.Expire(message.Timestamp, dedupMaxGroups) {
+				printGroup(group, queryOutputFormat)
+			}
+			if group, isNew := d.Add(message); isNew {
+				printGroup(group, queryOutputFormat)
+			}
+		case <-ticker.C:
+			for _, group := range d.Changed() {
+				printGroup(group, queryOutputFormat)
+			}
+		}
+	}
+}
+
+// Truncates a line so it takes up a single line on the terminal
+func truncateLine(s string, width int) string {
+	runes := []rune(s)
+	if width > 1 && len(runes) >= width {
+		return string(runes[:width-2]) + "…"
+	}
+	return s
+}
+
+// Prints a line per group, updating the counts of groups still on screen in
+// place by moving the cursor up to their line and back down again
+func followDeduplicatedTerminal(d *dedup.Deduplicator, messages <-chan common.LogMessage) {
+	fd := int(os.Stdout.Fd())
+	groupLines := make(map[*dedup.Group]int)
+	lines := 0
+	for message := range messages {
+		// Counts are updated on screen as they change, so there's nothing to report
+		d.Expire(message.Timestamp, dedupMaxGroups)
+		group, _ := d.Add(message)
+		width, height, err := terminal.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		line := truncateLine(formatGroup(group, false), width)
+		if groupLine, ok := groupLines[group]; ok && lines-groupLine < height {
+			up := lines - groupLine
+			fmt.Printf("\033[%dA\r\033[2K%s\033[%dB\r", up, line, up)
+			continue
+		}
+		fmt.Println(line)
+		groupLines[group] = lines
+		lines++
+		// Forget lines scrolled off the screen, they can't be updated anymore
+		for g, groupLine := range groupLines {
+			if lines-groupLine >= height {
+				delete(groupLines, g)
+			}
+		}
+	}
+}
+
+func dedupMain(messages <-chan common.LogMessage, follow bool, fields []string, window time.Duration, queryOutputFormat string) {
+	d := dedup.New(fields, window)
+	switch {
+	case !follow:
+		printDeduplicated(d, messages, queryOutputFormat)
+	case queryOutputFormat == "text" && terminal.IsTerminal(int(os.Stdout.Fd())):
+		followDeduplicatedTerminal(d, messages)
+	default:
+		followDeduplicated(d, messages, queryOutputFormat)
+	}
+}
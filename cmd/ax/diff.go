@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/backend/stream"
+	"github.com/egnyte/ax/pkg/config"
+	"github.com/egnyte/ax/pkg/patterns"
+	"github.com/egnyte/ax/pkg/stats"
+	"github.com/egnyte/ax/pkg/timespec"
+	"github.com/olekukonko/tablewriter"
+)
+
+var (
+	diffFlags            = addQueryFlags(diffCommand)
+	diffFlagBaseline     string
+	diffFlagCurrent      string
+	diffFlagBy           []string
+	diffFlagMaxResults   int
+	diffFlagThreshold    float64
+	diffFlagOutputFormat string
+)
+
+func init() {
+	diffCommand.Flag("baseline", "Time range to compare against, e.g. '2h ago..1h ago'").Required().StringVar(&diffFlagBaseline)
+	diffCommand.Flag("current", "Time range to compare, e.g. '1h ago..now'").Required().StringVar(&diffFlagCurrent)
+	diffCommand.Flag("by", "Compare values of these attributes rather than message patterns (repeatable)").HintAction(selectHintAction).StringsVar(&diffFlagBy)
+	diffCommand.Flag("results", "Maximum number of messages to analyze per time range, 0 for unlimited").Short('n').Default("0").IntVar(&diffFlagMaxResults)
+	diffCommand.Flag("threshold", "Factor by which the frequency has to change to be reported").Default("2").Float64Var(&diffFlagThreshold)
+	diffCommand.Flag("output", "Output format: text|json").Short('o').Default("text").EnumVar(&diffFlagOutputFormat, "text", "json")
+}
+
+func parseRangeFlag(name, expr string, now time.Time) (time.Time, time.Time) {
+	from, to, err := timespec.ParseRange(expr, now)
+	if err != nil {
+		fmt.Printf("Could not parse --%s: %v\n", name, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Parsed --%s as %s - %s\n", name, from.Format(common.TimeFormat), to.Format(common.TimeFormat))
+	return from, to
+}
+
+func printChanges(changes []stats.Change, outputFormat string) {
+	switch outputFormat {
+	case "text":
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Status", "Baseline", "Current", "Change", "Pattern"})
+		if len(diffFlagBy) > 0 {
+			table.SetHeader([]string{"Status", "Baseline", "Current", "Change", strings.Join(diffFlagBy, ", ")})
+		}
+		table.SetAutoWrapText(false)
+		for _, change := range changes {
+			factor := ""
+			if change.Status == stats.StatusChanged {
+				factor = fmt.Sprintf("×%.1f", change.Factor)
+			}
+			table.Append([]string{change.Status, strconv.Itoa(change.Baseline), strconv.Itoa(change.Current), factor, change.Key})
+		}
+		table.Render()
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, change := range changes {
+			if err := encoder.Encode(change); err != nil {
+				fmt.Println("Error JSON encoding")
+			}
+		}
+	}
+}
+
+func diffMain(rc config.RuntimeConfig, client common.Client) {
+	if diffFlags.After != "" || diffFlags.Before != "" || diffFlags.Last != "" || diffFlags.Around != "" {
+		fmt.Println("Use --baseline and --current to set the time ranges to compare")
+		os.Exit(1)
+	}
+	now := time.Now()
+	baselineFrom, baselineTo := parseRangeFlag("baseline", diffFlagBaseline, now)
+	currentFrom, currentTo := parseRangeFlag("current", diffFlagCurrent, now)
+	flags := withEnvDefaults(rc.Env, diffFlags)
+	query := querySelectorsToQuery(flags)
+	query.MaxResults = diffFlagMaxResults
+
+	// Patterns are mined from both time ranges together, so that they share templates
+	miner := patterns.NewMiner()
+	inRange := func(t, from, to time.Time) bool {
+		return !t.Before(from) && t.Before(to)
+	}
+	baselinePatterns := make(map[*patterns.Pattern]int)
+	currentPatterns := make(map[*patterns.Pattern]int)
+	baseline := make(map[string]int)
+	current := make(map[string]int)
+	count := func(message common.LogMessage, inBaseline, inCurrent bool) {
+		if len(diffFlagBy) > 0 {
+			key := strings.Join(stats.GroupValues(message, diffFlagBy), ", ")
+			if inBaseline {
+				baseline[key]++
+			}
+			if inCurrent {
+				current[key]++
+			}
+		} else if text, ok := patterns.MessageText(message); ok {
+			if pattern := miner.Add(text); pattern != nil {
+				if inBaseline {
+					baselinePatterns[pattern]++
+				}
+				if inCurrent {
+					currentPatterns[pattern]++
+				}
+			}
+		}
+	}
+	if _, ok := client.(*stream.Client); ok {
+		// Piped input can only be read once, so both ranges are covered by a
+		// single pass
+		query.After = &baselineFrom
+		if currentFrom.Before(baselineFrom) {
+			query.After = &currentFrom
+		}
+		query.Before = &currentTo
+		if baselineTo.After(currentTo) {
+			query.Before = &baselineTo
+		}
+		for message := range queryMessages(rc, rc.Env, client, query, flags) {
+			count(message, inRange(message.Timestamp, baselineFrom, baselineTo), inRange(message.Timestamp, currentFrom, currentTo))
+		}
+	} else {
+		// A query per range, each with its own limit, so the ranges don't
+		// compete for results and the gap between them isn't fetched
+		for i, r := range [][2]time.Time{{baselineFrom, baselineTo}, {currentFrom, currentTo}} {
+			from, to := r[0], r[1]
+			query.After = &from
+			query.Before = &to
+			for message := range queryMessages(rc, rc.Env, client, query, flags) {
+				if inRange(message.Timestamp, from, to) {
+					count(message, i == 0, i == 1)
+				}
+			}
+		}
+	}
+	// Templates are only final once all messages have been mined
+	for pattern, n := range baselinePatterns {
+		baseline[pattern.Template()] += n
+	}
+	for pattern, n := range currentPatterns {
+		current[pattern.Template()] += n
+	}
+	changes := stats.Compare(baseline, current, baselineTo.Sub(baselineFrom), currentTo.Sub(currentFrom), diffFlagThreshold)
+	printChanges(changes, diffFlagOutputFormat)
+}
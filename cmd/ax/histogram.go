@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/config"
+	"github.com/egnyte/ax/pkg/stats"
+	"github.com/egnyte/ax/pkg/timespec"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	histogramFlags            = addQueryFlags(histogramCommand)
+	histogramFlagInterval     string
+	histogramFlagOutputFormat string
+)
+
+func init() {
+	histogramCommand.Flag("interval", "Bucket size, e.g. '1m' (default: automatic)").StringVar(&histogramFlagInterval)
+	histogramCommand.Flag("output", "Output format: text|json|csv").Short('o').Default("text").EnumVar(&histogramFlagOutputFormat, "text", "json", "csv")
+}
+
+func printHistogram(buckets []common.TimeBucket, outputFormat string) {
+	switch outputFormat {
+	case "text":
+		width := 80
+		if w, _, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil {
+			width = w
+		}
+		max := 0
+		for _, bucket := range buckets {
+			if bucket.Count > max {
+				max = bucket.Count
+			}
+		}
+		countWidth := len(strconv.Itoa(max))
+		// Leave room for the timestamp, count and separators
+		barWidth := width - len(common.TimeFormat) - countWidth - 4
+		if barWidth < 10 {
+			barWidth = 10
+		}
+		for _, bucket := range buckets {
+			fmt.Printf("%s %*d │%s\n", bucket.Start.Format(common.TimeFormat), countWidth, bucket.Count, stats.Bar(bucket.Count, max, barWidth))
+		}
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, bucket := range buckets {
+			if err := encoder.Encode(bucket); err != nil {
+				fmt.Println("Error JSON encoding")
+			}
+		}
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write([]string{"start", "count"})
+		for _, bucket := range buckets {
+			writer.Write([]string{bucket.Start.Format(time.RFC3339), strconv.Itoa(bucket.Count)})
+		}
+		writer.Flush()
+	}
+}
+
+func histogramMain(rc config.RuntimeConfig, client common.Client) {
+	flags := withEnvDefaults(rc.Env, histogramFlags)
+	query := querySelectorsToQuery(flags)
+	query.MaxResults = 0
+	var interval time.Duration
+	if histogramFlagInterval != "" {
+		var err error
+		interval, err = timespec.ParseDuration(histogramFlagInterval)
+		if err != nil || interval < time.Second {
+			fmt.Println("Invalid --interval, it should be at least 1s:", histogramFlagInterval)
+			os.Exit(1)
+		}
+	}
+	if interval > 0 && query.After != nil {
+		// Fail before fetching anything when the time range is known
+		before := time.Now()
+		if query.Before != nil {
+			before = *query.Before
+		}
+		if err := common.CheckHistogramInterval(*query.After, before, interval); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	var buckets []common.TimeBucket
+	if histogrammer, ok := client.(common.Histogrammer); ok && !needsLocalProcessing(rc, rc.Env, query, flags) {
+		var err error
+		buckets, err = histogrammer.Histogram(query, interval)
+		if err != nil {
+			fmt.Println("Could not build histogram:", err)
+			os.Exit(1)
+		}
+	} else {
+		var err error
+		buckets, err = stats.Histogram(queryMessages(rc, rc.Env, client, query, flags), interval)
+		if err != nil {
+			fmt.Println("Could not build histogram:", err)
+			os.Exit(1)
+		}
+	}
+	printHistogram(buckets, histogramFlagOutputFormat)
+}
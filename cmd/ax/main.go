@@ -16,10 +16,19 @@ import (
 )
 
 var (
-	queryCommand    = kingpin.Command("query", "Query logs").Default()
-	alertCommand    = kingpin.Command("alert", "Be alerted when logs match a query")
-	alertDCommand   = kingpin.Command("alertd", "Be alerted when logs match a query")
-	addAlertCommand = alertCommand.Command("add", "Add new alert")
+	queryCommand       = kingpin.Command("query", "Query logs").Default()
+	statsCommand       = kingpin.Command("stats", "Count logs grouped by attributes")
+	histogramCommand   = kingpin.Command("histogram", "Count logs over time")
+	patternsCommand    = kingpin.Command("patterns", "Find common message patterns")
+	diffCommand        = kingpin.Command("diff", "Compare message patterns between two time ranges")
+	runCommand         = kingpin.Command("run", "Run a saved query")
+	savedCommand       = kingpin.Command("saved", "Saved query management commands")
+	savedAddCommand    = savedCommand.Command("add", "Save a query")
+	savedListCommand   = savedCommand.Command("list", "List saved queries").Default()
+	savedRemoveCommand = savedCommand.Command("remove", "Remove a saved query")
+	alertCommand       = kingpin.Command("alert", "Be alerted when logs match a query")
+	alertDCommand      = kingpin.Command("alertd", "Be alerted when logs match a query")
+	addAlertCommand    = alertCommand.Command("add", "Add new alert")
 )
 
 func determineClient(em config.EnvMap) common.Client {
@@ -55,7 +64,39 @@ func main() {
 			fmt.Println("No default environment set, please use the --env flag to set one. Exiting.")
 			return
 		}
-		queryMain(rc, client)
+		queryMain(rc, client, queryFlags, queryOptionFlags)
+	case "stats":
+		if client == nil {
+			fmt.Println("No default environment set, please use the --env flag to set one. Exiting.")
+			return
+		}
+		statsMain(rc, client)
+	case "histogram":
+		if client == nil {
+			fmt.Println("No default environment set, please use the --env flag to set one. Exiting.")
+			return
+		}
+		histogramMain(rc, client)
+	case "patterns":
+		if client == nil {
+			fmt.Println("No default environment set, please use the --env flag to set one. Exiting.")
+			return
+		}
+		patternsMain(rc, client)
+	case "diff":
+		if client == nil {
+			fmt.Println("No default environment set, please use the --env flag to set one. Exiting.")
+			return
+		}
+		diffMain(rc, client)
+	case "run":
+		runMain(rc)
+	case "saved add":
+		addSavedMain(rc)
+	case "saved list":
+		listSavedMain()
+	case "saved remove":
+		removeSavedMain()
 	case "env add":
 		config.AddEnv()
 	case "env list":
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/config"
+	"github.com/egnyte/ax/pkg/patterns"
+	"github.com/fatih/color"
+)
+
+var (
+	patternsFlags            = addQueryFlags(patternsCommand)
+	patternsFlagMaxResults   int
+	patternsFlagTop          int
+	patternsFlagOutputFormat string
+)
+
+func init() {
+	patternsCommand.Flag("results", "Number of messages to analyze, 0 for unlimited").Short('n').Default("10000").IntVar(&patternsFlagMaxResults)
+	patternsCommand.Flag("top", "Number of patterns to show, 0 for all").Default("20").IntVar(&patternsFlagTop)
+	patternsCommand.Flag("output", "Output format: text|json").Short('o').Default("text").EnumVar(&patternsFlagOutputFormat, "text", "json")
+}
+
+func printPatterns(found []*patterns.Pattern, outputFormat string) {
+	switch outputFormat {
+	case "text":
+		for _, pattern := range found {
+			fmt.Printf("%8d  %s\n", pattern.Count, color.New(color.Bold).Sprint(pattern.Template()))
+			fmt.Printf("          e.g. %s\n", pattern.Example)
+			fmt.Printf("          id   %s\n", color.New(color.FgMagenta).Sprint(pattern.ID()))
+		}
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, pattern := range found {
+			err := encoder.Encode(map[string]interface{}{
+				"id":       pattern.ID(),
+				"count":    pattern.Count,
+				"template": pattern.Template(),
+				"example":  pattern.Example,
+			})
+			if err != nil {
+				fmt.Println("Error JSON encoding")
+			}
+		}
+	}
+}
+
+func patternsMain(rc config.RuntimeConfig, client common.Client) {
+	flags := withEnvDefaults(rc.Env, patternsFlags)
+	query := querySelectorsToQuery(flags)
+	query.MaxResults = patternsFlagMaxResults
+	miner := patterns.Mine(queryMessages(rc, rc.Env, client, query, flags))
+	found := miner.Top(patternsFlagTop)
+	printPatterns(found, patternsFlagOutputFormat)
+}
@@ -4,14 +4,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 	"time"
 
-	"github.com/araddon/dateparse"
 	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/backend/kibana"
 	"github.com/egnyte/ax/pkg/complete"
 	"github.com/egnyte/ax/pkg/config"
+	"github.com/egnyte/ax/pkg/grok"
+	"github.com/egnyte/ax/pkg/patterns"
+	"github.com/egnyte/ax/pkg/syslog"
+	"github.com/egnyte/ax/pkg/timespec"
 	"github.com/fatih/color"
 	"github.com/zefhemel/kingpin"
 	yaml "gopkg.in/yaml.v2"
@@ -19,32 +24,76 @@ import (
 
 func addQueryFlags(cmd *kingpin.CmdClause) *common.QuerySelectors {
 	flags := &common.QuerySelectors{}
-	cmd.Flag("before", "Results from before").StringVar(&flags.Before)
-	cmd.Flag("after", "Results from after").StringVar(&flags.After)
+	cmd.Flag("before", "Results from before, e.g. '2017-10-17 10:00', '2h ago' or 'yesterday'").StringVar(&flags.Before)
+	cmd.Flag("after", "Results from after, e.g. '2017-10-17 10:00', '15m' or 'today'").StringVar(&flags.After)
+	cmd.Flag("last", "Results from the last period of time, e.g. '1h'").StringVar(&flags.Last)
+	cmd.Flag("around", "Results around a point in time, e.g. '2017-10-17T10:00 ±5m'").StringVar(&flags.Around)
 	cmd.Flag("select", "Fields to select").Short('s').HintAction(selectHintAction).StringsVar(&flags.Select)
 	cmd.Flag("where", "Add a filter").Short('w').HintAction(whereHintAction).StringsVar(&flags.Where)
+	cmd.Flag("expr", "Boolean filter expression, e.g. '(level=error OR level=fatal) AND NOT \"timeout\"'").Short('x').StringVar(&flags.Expression)
+	cmd.Flag("sort", "Order of results: asc|desc").EnumVar(&flags.Sort, "asc", "desc")
+	cmd.Flag("sort-by", "Attribute to sort results on (default: @timestamp)").HintAction(selectHintAction).StringVar(&flags.SortBy)
+	cmd.Flag("pattern", "Only show messages matching a pattern ID from ax patterns").StringsVar(&flags.Pattern)
+	cmd.Flag("exclude-pattern", "Hide messages matching a pattern ID from ax patterns, e.g. known noise").StringsVar(&flags.ExcludePattern)
+	cmd.Flag("raw-lucene", "Pass the query string to Kibana as Lucene syntax, as is").BoolVar(&flags.RawLucene)
+	cmd.Flag("exclude", "Attributes to leave out of results, e.g. 'kubernetes.*'").HintAction(selectHintAction).StringsVar(&flags.Exclude)
+	cmd.Flag("rename", "Rename an attribute in results, e.g. log.level=level").StringsVar(&flags.Rename)
+	cmd.Flag("extract", "Regular expression with named groups to extract attributes from messages, e.g. '(?P<status>\\d{3})'").StringsVar(&flags.Extract)
+	cmd.Flag("parse", "How to parse lines that aren't JSON, e.g. syslog, grok:COMBINEDAPACHELOG or 'grok:%{IP:client} %{GREEDYDATA:message}'").StringVar(&flags.Parse)
+	cmd.Flag("record-start", "Regular expression matching the first line of multi-line messages, e.g. '^\\d{4}-' (default: stack traces are recognized)").StringsVar(&flags.RecordStart)
+	cmd.Flag("not", "Hide messages containing this phrase").StringsVar(&flags.Not)
+	cmd.Flag("case-sensitive", "Match the query string and --not phrases case sensitively").BoolVar(&flags.CaseSensitive)
 	cmd.Arg("query", "Query string").Default("").StringsVar(&flags.QueryString)
 	return flags
 }
 
-var (
-	queryFlags            = addQueryFlags(queryCommand)
-	queryFlagMaxResults   int
-	queryFlagOutputFormat string
-	queryFlagFollow       bool
-)
+// Flags controlling how query results are retrieved and shown
+type queryOptions struct {
+	MaxResults    int
+	OutputFormat  string
+	Follow        bool
+	Context       int
+	ContextBefore int
+	ContextAfter  int
+	ContextBy     string
+	Dedup         bool
+	DedupBy       []string
+	DedupWindow   string
+}
 
-func init() {
-	queryCommand.Flag("results", "Maximum number of results").Short('n').Default("50").IntVar(&queryFlagMaxResults)
-	queryCommand.Flag("output", "Output format: text|json|yaml").Short('o').Default("text").EnumVar(&queryFlagOutputFormat, "text", "yaml", "json", "pretty-json")
-	queryCommand.Flag("follow", "Follow log in quasi-realtime, similar to tail -f").Short('f').Default("false").BoolVar(&queryFlagFollow)
+func addQueryOptionFlags(cmd *kingpin.CmdClause) *queryOptions {
+	options := &queryOptions{}
+	cmd.Flag("results", "Maximum number of results, 0 for unlimited").Short('n').Default("50").IntVar(&options.MaxResults)
+	cmd.Flag("output", "Output format: text|json|yaml").Short('o').Default("text").EnumVar(&options.OutputFormat, "text", "yaml", "json", "pretty-json")
+	cmd.Flag("follow", "Follow log in quasi-realtime, similar to tail -f").Short('f').Default("false").BoolVar(&options.Follow)
+	cmd.Flag("context", "Number of messages of context to show around every match").Short('C').IntVar(&options.Context)
+	cmd.Flag("before-context", "Number of messages of context to show before every match").Short('B').IntVar(&options.ContextBefore)
+	cmd.Flag("after-context", "Number of messages of context to show after every match").Short('A').IntVar(&options.ContextAfter)
+	cmd.Flag("context-by", "Attribute context messages share with the match, e.g. host (required with Kibana, default: context_field of env)").HintAction(selectHintAction).StringVar(&options.ContextBy)
+	cmd.Flag("dedup", "Show repeated messages once, with a count").BoolVar(&options.Dedup)
+	cmd.Flag("dedup-by", "Consider messages repeated when these attributes are equal, rather than all (implies --dedup)").HintAction(selectHintAction).StringsVar(&options.DedupBy)
+	cmd.Flag("dedup-window", "Only group repeated messages within this time of each other, e.g. '5m'").StringVar(&options.DedupWindow)
+	return options
 }
 
+var (
+	queryFlags       = addQueryFlags(queryCommand)
+	queryOptionFlags = addQueryOptionFlags(queryCommand)
+)
+
 func whereHintAction() []string {
 	rc := config.BuildConfig()
 	resultList := make([]string, 0, 20)
 	for attrName, _ := range complete.GetCompletions(rc) {
-		resultList = append(resultList, fmt.Sprintf("%s=", attrName))
+		resultList = append(resultList, fmt.Sprintf("%s=", attrName), fmt.Sprintf("%s in (", attrName), fmt.Sprintf("%s notin (", attrName))
+	}
+	if counter, ok := determineClient(rc.Env).(common.GroupCounter); ok {
+		complete.SeedValues(rc, counter)
+	}
+	for attrName, values := range complete.GetValueCompletions(rc) {
+		for _, value := range values {
+			resultList = append(resultList, fmt.Sprintf("%s=%s", attrName, value))
+		}
 	}
 	return resultList
 }
@@ -58,85 +107,338 @@ func selectHintAction() []string {
 	return resultList
 }
 
-var filterRegex = regexp.MustCompile(`([^!=<>]+)\s*(=|!=)\s*(.*)`)
-
 func buildFilters(wheres []string) []common.QueryFilter {
 	filters := make([]common.QueryFilter, 0, len(wheres))
 	for _, whereClause := range wheres {
-		//pieces := strings.SplitN(whereClause, "=", 2)
-		matches := filterRegex.FindAllStringSubmatch(whereClause, -1)
-		if len(matches) != 1 {
-			fmt.Println("Invalid where clause", whereClause)
+		filter, err := common.ParseQueryFilter(whereClause)
+		if err != nil {
+			fmt.Println("Invalid where clause", whereClause, err)
 			os.Exit(1)
 		}
-		filters = append(filters, common.QueryFilter{
-			FieldName: matches[0][1],
-			Operator:  matches[0][2],
-			Value:     matches[0][3],
-		})
+		filters = append(filters, filter)
 	}
 	return filters
 }
 
+func buildExpression(s string) common.Expression {
+	if s == "" {
+		return nil
+	}
+	expr, err := common.ParseExpression(s)
+	if err != nil {
+		fmt.Println("Invalid expression:", err)
+		os.Exit(1)
+	}
+	return expr
+}
+
+func buildExcludes(globs []string) []string {
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			fmt.Println("Invalid --exclude pattern", glob, err)
+			os.Exit(1)
+		}
+	}
+	return globs
+}
+
+func buildRenames(renames []string) map[string]string {
+	renameMap := make(map[string]string)
+	for _, rename := range renames {
+		pieces := strings.SplitN(rename, "=", 2)
+		if len(pieces) != 2 || pieces[0] == "" || pieces[1] == "" {
+			fmt.Println("Invalid rename, expected old=new:", rename)
+			os.Exit(1)
+		}
+		renameMap[pieces[0]] = pieces[1]
+	}
+	return renameMap
+}
+
+// Splits a comma separated env setting, e.g. exclude: "kubernetes.*, beat.*"
+func splitEnvList(value string) []string {
+	values := make([]string, 0, 4)
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func parseTimeFlag(name, expr string, now time.Time) *time.Time {
+	ts, err := timespec.Parse(expr, now)
+	if err != nil {
+		fmt.Printf("Could not parse --%s: %v\n", name, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Parsed --%s as %s\n", name, ts.Format(common.TimeFormat))
+	return &ts
+}
+
+// Resolves pattern IDs to templates, returns nil when there are no pattern filters
+func buildPatternFilter(rc config.RuntimeConfig, flags *common.QuerySelectors) *patterns.Filter {
+	if len(flags.Pattern) == 0 && len(flags.ExcludePattern) == 0 {
+		return nil
+	}
+	lookup := func(ids []string) []string {
+		templates := make([]string, 0, len(ids))
+		for _, id := range ids {
+			template, err := patterns.TemplateFromID(id)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			templates = append(templates, template)
+		}
+		return templates
+	}
+	return &patterns.Filter{
+		Include: lookup(flags.Pattern),
+		Exclude: lookup(flags.ExcludePattern),
+	}
+}
+
+// Combines flags with a setting of the env holding one value per line
+func withEnvLines(env config.EnvMap, key string, values []string) []string {
+	lines := make([]string, 0, len(values)+1)
+	for _, line := range append(strings.Split(env[key], "\n"), values...) {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Compiles --record-start flags and the record_start setting of the env
+func buildRecordStart(env config.EnvMap, flags *common.QuerySelectors) []*regexp.Regexp {
+	expressions := withEnvLines(env, "record_start", flags.RecordStart)
+	regexes := make([]*regexp.Regexp, 0, len(expressions))
+	for _, expression := range expressions {
+		regex, err := regexp.Compile(expression)
+		if err != nil {
+			fmt.Println("Invalid --record-start", expression, err)
+			os.Exit(1)
+		}
+		regexes = append(regexes, regex)
+	}
+	return regexes
+}
+
+// Compiles --extract flags and the extract setting of the env, one regular
+// expression per line
+func buildExtractors(env config.EnvMap, flags *common.QuerySelectors) common.Extractors {
+	expressions := withEnvLines(env, "extract", flags.Extract)
+	extractors := make(common.Extractors, 0, len(expressions))
+	for _, expression := range expressions {
+		extractor, err := common.ParseExtractor(expression)
+		if err != nil {
+			fmt.Println("Invalid extraction", expression, err)
+			os.Exit(1)
+		}
+		extractors = append(extractors, extractor)
+	}
+	return extractors
+}
+
+// Builds the parser selected with --parse or the parse setting of the env
+func buildParser(rc config.RuntimeConfig, env config.EnvMap, flags *common.QuerySelectors) common.LineParser {
+	spec := flags.Parse
+	if spec == "" {
+		spec = env["parse"]
+	}
+	if spec == "" {
+		return nil
+	}
+	pieces := strings.SplitN(spec, ":", 2)
+	switch {
+	case pieces[0] == "grok" && len(pieces) == 2:
+		pattern, err := grok.New(rc.Config.GrokPatterns).Compile(pieces[1])
+		if err != nil {
+			fmt.Println("Invalid grok pattern:", err)
+			os.Exit(1)
+		}
+		return pattern
+	case spec == "syslog":
+		return syslog.Parser{AllowRFC3339: true}
+	default:
+		fmt.Println("Unsupported --parse, expected grok:PATTERN or syslog:", spec)
+		os.Exit(1)
+	}
+	return nil
+}
+
+// Whether the query needs messages to be processed locally, rather than
+// aggregated by the backend
+func needsLocalProcessing(rc config.RuntimeConfig, env config.EnvMap, query common.Query, flags *common.QuerySelectors) bool {
+	return buildPatternFilter(rc, flags) != nil || len(buildExtractors(env, flags)) > 0 || query.CaseSensitive
+}
+
+// Queries the client, applying the extractions and filters that are evaluated
+// locally. Settings such as extract and parse are read from env, the
+// environment the client was created for.
+func queryMessages(rc config.RuntimeConfig, env config.EnvMap, client common.Client, query common.Query, flags *common.QuerySelectors) <-chan common.LogMessage {
+	if _, ok := client.(*kibana.Client); query.RawQueryString && !ok {
+		fmt.Fprintln(os.Stderr, "--raw-lucene is only supported with Kibana, using the regular query string syntax")
+	}
+	if _, ok := client.(*kibana.Client); flags.Parse != "" && ok {
+		fmt.Fprintln(os.Stderr, "--parse is not supported with Kibana, messages are already parsed")
+	}
+	query.Parser = buildParser(rc, env, flags)
+	query.RecordStart = buildRecordStart(env, flags)
+	var messages <-chan common.LogMessage
+	if extractors := buildExtractors(env, flags); len(extractors) > 0 {
+		messages = extractors.Query(client, query)
+	} else {
+		messages = client.Query(query)
+	}
+	if patternFilter := buildPatternFilter(rc, flags); patternFilter != nil {
+		messages = patternFilter.FilterMessages(messages)
+	}
+	return messages
+}
+
 func querySelectorsToQuery(flags *common.QuerySelectors) common.Query {
 	var before *time.Time
 	var after *time.Time
+	now := time.Now()
+	if flags.Last != "" && (flags.After != "" || flags.Around != "") {
+		fmt.Println("--last cannot be combined with --after or --around")
+		os.Exit(1)
+	}
+	if flags.Around != "" && (flags.After != "" || flags.Before != "") {
+		fmt.Println("--around cannot be combined with --after or --before")
+		os.Exit(1)
+	}
 	if flags.After != "" {
-		var err error
-		afterTime, err := dateparse.ParseLocal(flags.After)
+		after = parseTimeFlag("after", flags.After, now)
+	}
+	if flags.Before != "" {
+		before = parseTimeFlag("before", flags.Before, now)
+	}
+	if flags.Last != "" {
+		d, err := timespec.ParseDuration(flags.Last)
 		if err != nil {
-			fmt.Println("Could parse after date:", flags.After)
+			fmt.Println("Could not parse --last:", err)
 			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "Parsed --after as %s", afterTime.Format(common.TimeFormat))
+		afterTime := now.Add(-d)
 		after = &afterTime
 	}
-	if flags.Before != "" {
-		var err error
-		beforeTime, err := dateparse.ParseLocal(flags.Before)
+	if flags.Around != "" {
+		afterTime, beforeTime, err := timespec.ParseAround(flags.Around, now)
 		if err != nil {
-			fmt.Println("Could parse before date:", flags.Before)
+			fmt.Println("Could not parse --around:", err)
 			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "Parsed --before as %s", beforeTime.Format(common.TimeFormat))
+		fmt.Fprintf(os.Stderr, "Parsed --around as %s - %s\n", afterTime.Format(common.TimeFormat), beforeTime.Format(common.TimeFormat))
+		after = &afterTime
 		before = &beforeTime
 	}
 
 	return common.Query{
-		QueryString:  strings.Join(flags.QueryString, " "),
-		Before:       before,
-		After:        after,
-		Filters:      buildFilters(flags.Where),
-		SelectFields: flags.Select,
+		QueryString:    strings.Join(flags.QueryString, " "),
+		RawQueryString: flags.RawLucene,
+		ExcludePhrases: flags.Not,
+		CaseSensitive:  flags.CaseSensitive,
+		Before:         before,
+		After:          after,
+		Filters:        buildFilters(flags.Where),
+		Expression:     buildExpression(flags.Expression),
+		SelectFields:   flags.Select,
+		ExcludeFields:  buildExcludes(flags.Exclude),
+		RenameFields:   buildRenames(flags.Rename),
+		SortOrder:      flags.Sort,
+		SortField:      flags.SortBy,
+	}
+}
+
+// Adds the exclude and rename settings of the env to flags. They come first, so
+// --rename can override them.
+func withEnvDefaults(env config.EnvMap, flags *common.QuerySelectors) *common.QuerySelectors {
+	envDefaults := common.QuerySelectors{
+		Exclude: splitEnvList(env["exclude"]),
+		Rename:  splitEnvList(env["rename"]),
 	}
+	layered := envDefaults.Layer(*flags)
+	return &layered
 }
 
-func queryMain(rc config.RuntimeConfig, client common.Client) {
-	query := querySelectorsToQuery(queryFlags)
-	query.MaxResults = queryFlagMaxResults
-	query.Follow = queryFlagFollow
-	for message := range complete.GatherCompletionInfo(rc, client.Query(query)) {
-		printMessage(message, queryFlagOutputFormat)
+func queryMain(rc config.RuntimeConfig, client common.Client, flags *common.QuerySelectors, options *queryOptions) {
+	flags = withEnvDefaults(rc.Env, flags)
+	query := querySelectorsToQuery(flags)
+	query.MaxResults = options.MaxResults
+	query.Follow = options.Follow
+	query.ContextBefore = options.Context
+	query.ContextAfter = options.Context
+	if options.ContextBefore > 0 {
+		query.ContextBefore = options.ContextBefore
+	}
+	if options.ContextAfter > 0 {
+		query.ContextAfter = options.ContextAfter
+	}
+	query.ContextField = options.ContextBy
+	if query.ContextField == "" {
+		query.ContextField = rc.Env["context_field"]
+	}
+	dedup := options.Dedup || len(options.DedupBy) > 0
+	var dedupWindow time.Duration
+	if dedup {
+		if query.ContextBefore > 0 || query.ContextAfter > 0 {
+			fmt.Println("--dedup cannot be combined with context")
+			os.Exit(1)
+		}
+		if options.DedupWindow != "" {
+			var err error
+			dedupWindow, err = timespec.ParseDuration(options.DedupWindow)
+			if err != nil {
+				fmt.Println("Could not parse --dedup-window:", err)
+				os.Exit(1)
+			}
+		}
+	}
+	messages := complete.GatherCompletionInfo(rc, queryMessages(rc, rc.Env, client, query, flags))
+	if dedup {
+		dedupMain(messages, query.Follow, options.DedupBy, dedupWindow, options.OutputFormat)
+		return
 	}
+	for message := range messages {
+		printMessage(message, options.OutputFormat)
+	}
+
+}
 
+func formatText(message common.LogMessage, highlight bool) string {
+	var sb strings.Builder
+	colorize := func(c *color.Color, s string) string {
+		if highlight {
+			return c.Sprint(s)
+		}
+		return s
+	}
+	ts := message.Timestamp.Format(common.TimeFormat)
+	fmt.Fprintf(&sb, "[%s] ", colorize(color.New(color.FgMagenta), ts))
+	if msg, ok := message.Attributes["message"].(string); ok {
+		fmt.Fprintf(&sb, "%s ", colorize(color.New(color.Bold), msg))
+	}
+	for key, value := range message.Attributes {
+		if key == "message" || value == nil {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s=%+v ", colorize(color.New(color.FgCyan), key), value)
+	}
+	return sb.String()
 }
 
 func printMessage(message common.LogMessage, queryOutputFormat string) {
 	switch queryOutputFormat {
 	case "text":
-		ts := message.Timestamp.Format(common.TimeFormat)
-		fmt.Printf("[%s] ", color.MagentaString(ts))
-		if msg, ok := message.Attributes["message"].(string); ok {
-			messageColor := color.New(color.Bold)
-			fmt.Printf("%s ", messageColor.Sprint(msg))
-		}
-		for key, value := range message.Attributes {
-			if key == "message" || value == nil {
-				continue
-			}
-			fmt.Printf("%s=%+v ", color.CyanString(key), value)
+		if message.IsContext {
+			// Context messages are indented and shown dimmed without highlighting
+			color.New(color.Faint).Println("  " + formatText(message, false))
+		} else {
+			fmt.Println(formatText(message, true))
 		}
-		fmt.Println()
 	case "json":
 		encoder := json.NewEncoder(os.Stdout)
 		err := encoder.Encode(message.Map())
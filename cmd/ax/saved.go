@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/config"
+	"github.com/olekukonko/tablewriter"
+)
+
+var (
+	// Names are declared before the query flags, as their query string argument comes after them
+	savedAddName    = savedAddCommand.Arg("name", "Name for the saved query").Required().String()
+	savedAddFlags   = addQueryFlags(savedAddCommand)
+	savedRemoveName = savedRemoveCommand.Arg("name", "Name of the saved query").Required().HintAction(config.SavedQueryHintAction).String()
+	runName         = runCommand.Arg("name", "Name of the saved query").Required().HintAction(config.SavedQueryHintAction).String()
+	runFlags        = addQueryFlags(runCommand)
+	runOptionFlags  = addQueryOptionFlags(runCommand)
+	runParams       = runCommand.Flag("param", "Value for a parameter of the saved query, e.g. service=api").HintAction(config.SavedQueryParamHintAction).StringMap()
+)
+
+// Summarizes selectors in roughly the flags that would produce them
+func describeSelectors(s common.QuerySelectors) string {
+	pieces := make([]string, 0, 10)
+	add := func(flag string, values ...string) {
+		for _, value := range values {
+			if value != "" {
+				pieces = append(pieces, fmt.Sprintf("--%s %q", flag, value))
+			}
+		}
+	}
+	add("after", s.After)
+	add("before", s.Before)
+	add("last", s.Last)
+	add("around", s.Around)
+	add("where", s.Where...)
+	add("expr", s.Expression)
+	add("select", s.Select...)
+	add("sort", s.Sort)
+	add("sort-by", s.SortBy)
+	add("pattern", s.Pattern...)
+	add("exclude-pattern", s.ExcludePattern...)
+	add("exclude", s.Exclude...)
+	add("rename", s.Rename...)
+	add("parse", s.Parse)
+	add("record-start", s.RecordStart...)
+	add("extract", s.Extract...)
+	add("not", s.Not...)
+	if s.RawLucene {
+		pieces = append(pieces, "--raw-lucene")
+	}
+	if s.CaseSensitive {
+		pieces = append(pieces, "--case-sensitive")
+	}
+	if queryString := strings.TrimSpace(strings.Join(s.QueryString, " ")); queryString != "" {
+		pieces = append(pieces, fmt.Sprintf("%q", queryString))
+	}
+	return strings.Join(pieces, " ")
+}
+
+func addSavedMain(rc config.RuntimeConfig) {
+	conf := config.LoadConfig()
+	conf.SaveQuery(config.SavedQuery{
+		Name:     *savedAddName,
+		Env:      rc.ActiveEnv,
+		Selector: *savedAddFlags,
+	})
+	config.SaveConfig(conf)
+	fmt.Println("Saved query", *savedAddName)
+}
+
+func listSavedMain() {
+	conf := config.LoadConfig()
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Env", "Query"})
+	table.SetAutoWrapText(false)
+	for _, saved := range conf.Saved {
+		table.Append([]string{saved.Name, saved.Env, describeSelectors(saved.Selector)})
+	}
+	table.Render()
+}
+
+func removeSavedMain() {
+	conf := config.LoadConfig()
+	if !conf.RemoveSavedQuery(*savedRemoveName) {
+		fmt.Println("No such saved query:", *savedRemoveName)
+		os.Exit(1)
+	}
+	config.SaveConfig(conf)
+}
+
+// Runs a saved query in its environment (unless --env is given) with the
+// query flags given on the command line layered on top
+func runMain(rc config.RuntimeConfig) {
+	saved := rc.Config.FindSavedQuery(*runName)
+	if saved == nil {
+		fmt.Println("No such saved query:", *runName)
+		os.Exit(1)
+	}
+	selectors, err := saved.Expand(*runParams)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if rc.ActiveEnv == "" && saved.Env != "" {
+		env, ok := rc.Config.Environments[saved.Env]
+		if !ok {
+			fmt.Println("Undefined environment for saved query:", saved.Env)
+			os.Exit(1)
+		}
+		rc.ActiveEnv = saved.Env
+		rc.Env = env
+	}
+	client := determineClient(rc.Env)
+	if client == nil {
+		fmt.Println("No default environment set, please use the --env flag to set one. Exiting.")
+		return
+	}
+	layered := selectors.Layer(*runFlags)
+	queryMain(rc, client, &layered, runOptionFlags)
+}
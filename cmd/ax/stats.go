@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/config"
+	"github.com/egnyte/ax/pkg/stats"
+	"github.com/olekukonko/tablewriter"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// How often the table is refreshed in follow mode
+const statsRefreshInterval = 5 * time.Second
+
+var (
+	statsFlags            = addQueryFlags(statsCommand)
+	statsFlagBy           []string
+	statsFlagTop          int
+	statsFlagFollow       bool
+	statsFlagOutputFormat string
+)
+
+func init() {
+	statsCommand.Flag("by", "Attribute to group by (repeatable)").HintAction(selectHintAction).StringsVar(&statsFlagBy)
+	statsCommand.Flag("top", "Number of groups to show, 0 for all").Default("10").IntVar(&statsFlagTop)
+	statsCommand.Flag("follow", "Keep counting and refresh the table periodically").Short('f').Default("false").BoolVar(&statsFlagFollow)
+	statsCommand.Flag("output", "Output format: text|json").Short('o').Default("text").EnumVar(&statsFlagOutputFormat, "text", "json")
+}
+
+func printStats(fields []string, groups []common.GroupCount, outputFormat string, redraw bool) {
+	switch outputFormat {
+	case "text":
+		if redraw {
+			// Clear the screen and move the cursor home
+			fmt.Print("\033[H\033[2J")
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(append(append([]string{}, fields...), "Count"))
+		for _, group := range groups {
+			table.Append(append(append([]string{}, group.Values...), strconv.Itoa(group.Count)))
+		}
+		table.Render()
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, group := range groups {
+			row := make(map[string]interface{}, len(fields)+1)
+			for i, field := range fields {
+				row[field] = group.Values[i]
+			}
+			row["count"] = group.Count
+			if err := encoder.Encode(row); err != nil {
+				fmt.Println("Error JSON encoding")
+			}
+		}
+	}
+}
+
+// Maps attributes renamed with --rename back to the names the backend knows them by
+func originalFields(query common.Query, fields []string) []string {
+	original := make([]string, 0, len(fields))
+	for _, field := range fields {
+		for oldName, newName := range query.RenameFields {
+			if newName == field {
+				field = oldName
+				break
+			}
+		}
+		original = append(original, field)
+	}
+	return original
+}
+
+// Counts server side, polling again every refresh interval in follow mode
+func serverStats(counter common.GroupCounter, query common.Query, redraw bool) {
+	for {
+		if query.Follow {
+			now := time.Now()
+			query.Before = &now
+		}
+		groups, err := counter.CountBy(query, originalFields(query, statsFlagBy), statsFlagTop)
+		if err != nil {
+			fmt.Println("Could not count messages:", err)
+			os.Exit(1)
+		}
+		printStats(statsFlagBy, groups, statsFlagOutputFormat, redraw)
+		if !query.Follow {
+			return
+		}
+		time.Sleep(statsRefreshInterval)
+	}
+}
+
+// Fetches all matching messages and counts them locally
+func localStats(rc config.RuntimeConfig, client common.Client, query common.Query, flags *common.QuerySelectors, redraw bool) {
+	counter := stats.NewCounter(statsFlagBy)
+	messages := queryMessages(rc, rc.Env, client, query, flags)
+	if !query.Follow {
+		for message := range messages {
+			counter.Add(message)
+		}
+		printStats(statsFlagBy, counter.Top(statsFlagTop), statsFlagOutputFormat, redraw)
+		return
+	}
+	ticker := time.NewTicker(statsRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				printStats(statsFlagBy, counter.Top(statsFlagTop), statsFlagOutputFormat, redraw)
+				return
+			}
+			counter.Add(message)
+		case <-ticker.C:
+			printStats(statsFlagBy, counter.Top(statsFlagTop), statsFlagOutputFormat, redraw)
+		}
+	}
+}
+
+func statsMain(rc config.RuntimeConfig, client common.Client) {
+	flags := withEnvDefaults(rc.Env, statsFlags)
+	query := querySelectorsToQuery(flags)
+	query.MaxResults = 0
+	query.Follow = statsFlagFollow
+	// Only redraw in place when refreshing a table on a terminal
+	redraw := statsFlagFollow && statsFlagOutputFormat == "text" && terminal.IsTerminal(int(os.Stdout.Fd()))
+	counter, ok := client.(common.GroupCounter)
+	if ok && !needsLocalProcessing(rc, rc.Env, query, flags) {
+		serverStats(counter, query, redraw)
+	} else {
+		localStats(rc, client, query, flags, redraw)
+	}
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// Value used for attributes a message doesn't have when grouping messages
+const MissingValue = "(missing)"
+
+// Number of messages sharing the same values for a list of attributes
+type GroupCount struct {
+	Values []string `json:"values"`
+	Count  int      `json:"count"`
+}
+
+// Implemented by backends that can count messages server side, rather than
+// having to fetch and count them one by one
+type GroupCounter interface {
+	// Counts messages matching the query grouped by the values of the given
+	// attributes, returning the top groups with the highest counts
+	CountBy(q Query, fields []string, top int) ([]GroupCount, error)
+}
+
+// Number of messages within a time interval starting at Start
+type TimeBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// Implemented by backends that can bucket messages by time server side
+type Histogrammer interface {
+	// Counts messages matching the query per interval of time, picking an
+	// interval automatically when interval is 0
+	Histogram(q Query, interval time.Duration) ([]TimeBucket, error)
+}
+
+// Roughly the number of buckets aimed for when picking an interval automatically
+const histogramBuckets = 60
+
+var histogramIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// Picks the smallest round interval splitting a time span into at most about 60 buckets
+func HistogramInterval(span time.Duration) time.Duration {
+	for _, interval := range histogramIntervals {
+		if span/interval <= histogramBuckets {
+			return interval
+		}
+	}
+	return histogramIntervals[len(histogramIntervals)-1]
+}
+
+// Upper bound on the number of buckets of a histogram, so a small --interval
+// over a long time span doesn't exhaust memory
+const MaxHistogramBuckets = 10000
+
+// Returns an error if splitting from until to by interval takes more than
+// MaxHistogramBuckets buckets
+func CheckHistogramInterval(from, to time.Time, interval time.Duration) error {
+	if n := int64(to.Sub(from.Truncate(interval))/interval) + 1; n > MaxHistogramBuckets {
+		return fmt.Errorf("An interval of %s splits the time range into %d buckets, at most %d are supported; please use a larger interval", interval, n, MaxHistogramBuckets)
+	}
+	return nil
+}
+
+// Creates (empty) buckets covering from until to
+func HistogramBuckets(from, to time.Time, interval time.Duration) ([]TimeBucket, error) {
+	if err := CheckHistogramInterval(from, to, interval); err != nil {
+		return nil, err
+	}
+	buckets := make([]TimeBucket, 0, histogramBuckets)
+	for start := from.Truncate(interval); !start.After(to); start = start.Add(interval) {
+		buckets = append(buckets, TimeBucket{Start: start})
+	}
+	return buckets, nil
+}
@@ -3,7 +3,11 @@ package common
 import (
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,39 +18,220 @@ type Client interface {
 	Query(query Query) <-chan LogMessage
 }
 
+// Implemented by clients that return at most q.MaxResults messages themselves
+// (such as Kibana), rather than every matching message like local inputs do
+type ResultLimiter interface {
+	LimitsResults() bool
+}
+
 type QueryFilter struct {
 	FieldName string
 	Operator  string
 	Value     string
+	// Values to compare against for the in and notin operators
+	Values []string
+	// Compiled version of Value for the =~ and !~ operators
+	regex *regexp.Regexp
+}
+
+var ErrorUnsupportedOperator = errors.New("Unsupported operator")
+
+// Builds a filter and validates it upfront, e.g. compiles regular expressions
+// once so that they don't have to be compiled for every message
+func NewQueryFilter(fieldName, operator, value string) (QueryFilter, error) {
+	filter := QueryFilter{
+		FieldName: fieldName,
+		Operator:  operator,
+		Value:     value,
+	}
+	switch operator {
+	case "=", "!=", "<", "<=", ">", ">=":
+	case "exists", "missing":
+		filter.Value = ""
+	case "in", "notin":
+		filter.Values = splitValueList(value)
+		filter.Value = strings.Join(filter.Values, ",")
+	case "=~", "!~":
+		regex, err := regexp.Compile(value)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid regular expression %q: %v", value, err)
+		}
+		filter.regex = regex
+	default:
+		return filter, ErrorUnsupportedOperator
+	}
+	return filter, nil
+}
+
+// Parses plain text log lines into attributes, e.g. with a grok pattern.
+// Returns false for lines it can't parse.
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, bool)
 }
 
 type Query struct {
-	QueryString  string
-	After        *time.Time
-	Before       *time.Time
-	SelectFields []string
+	QueryString string
+	// Pass the query string to the backend as is (Lucene syntax for Kibana),
+	// backends without a native query language use the regular syntax instead
+	RawQueryString bool
+	// Phrases that matching messages may not contain
+	ExcludePhrases []string
+	// Match the query string and excluded phrases case sensitively
+	CaseSensitive bool
+	After         *time.Time
+	Before        *time.Time
+	SelectFields  []string
+	// Glob patterns of attributes to leave out of results, e.g. kubernetes.*
+	ExcludeFields []string
+	// Attributes to rename in results, from old to new name
+	RenameFields map[string]string
 	Filters      []QueryFilter
+	Expression   Expression
 	MaxResults   int
-	// QueryAsc     bool
-	// ResultsDesy  bool
-	Follow bool
+	// Order in which results are returned: "asc" (default) or "desc"
+	SortOrder string
+	// Attribute to sort results on, defaults to @timestamp
+	SortField string
+	// Number of messages to include before and after every match, similar to grep -B and -A
+	ContextBefore int
+	ContextAfter  int
+	// Attribute (e.g. host) whose value context messages should share with the match,
+	// for backends that don't have a natural notion of a single source
+	ContextField string
+	Follow       bool
+	// Parser for lines that aren't JSON, used by backends reading raw lines
+	Parser LineParser
+	// Lines starting a new record for backends reading raw lines, other lines
+	// are joined to the record before them. Stack traces are recognized when empty.
+	RecordStart []*regexp.Regexp
 }
 
+// Time related selectors are kept as the expressions entered (e.g. "15m" or
+// "yesterday"), so that saved selectors are re-evaluated every time they are used
 type QuerySelectors struct {
 	Before      string   `yaml:"before,omitempty"`
 	After       string   `yaml:"after,omitempty"`
+	Last        string   `yaml:"last,omitempty"`
+	Around      string   `yaml:"around,omitempty"`
 	Select      []string `yaml:"select,omitempty"`
 	Where       []string `yaml:"where,omitempty"`
+	Expression  string   `yaml:"expr,omitempty"`
+	Sort        string   `yaml:"sort,omitempty"`
+	SortBy      string   `yaml:"sort_by,omitempty"`
 	QueryString []string `yaml:"query,omitempty"`
+	// IDs of patterns found by ax patterns, applied locally after querying
+	Pattern        []string `yaml:"pattern,omitempty"`
+	ExcludePattern []string `yaml:"exclude_pattern,omitempty"`
+	RawLucene      bool     `yaml:"raw_lucene,omitempty"`
+	Exclude        []string `yaml:"exclude,omitempty"`
+	// Renames as old=new
+	Rename []string `yaml:"rename,omitempty"`
+	Not    []string `yaml:"not,omitempty"`
+	// Regular expressions with named groups to extract attributes from messages
+	Extract []string `yaml:"extract,omitempty"`
+	// How to parse lines, e.g. grok:COMBINEDAPACHELOG
+	Parse string `yaml:"parse,omitempty"`
+	// Regular expressions matching the first line of multi-line records
+	RecordStart   []string `yaml:"record_start,omitempty"`
+	CaseSensitive bool     `yaml:"case_sensitive,omitempty"`
+}
+
+// Layers selectors on top of these (e.g. flags on top of a saved query).
+// Filters, selected, excluded and renamed fields, patterns, excluded phrases,
+// extractions, record starts and query strings are added, time ranges, sorting and other settings are replaced when set.
+// Expressions are ANDed.
+func (s QuerySelectors) Layer(top QuerySelectors) QuerySelectors {
+	result := s
+	if top.Before != "" || top.After != "" || top.Last != "" || top.Around != "" {
+		// Replace the whole time range, combining e.g. --last with --after makes no sense
+		result.Before, result.After, result.Last, result.Around = top.Before, top.After, top.Last, top.Around
+	}
+	if top.Expression != "" {
+		if result.Expression != "" {
+			result.Expression = fmt.Sprintf("(%s) AND (%s)", result.Expression, top.Expression)
+		} else {
+			result.Expression = top.Expression
+		}
+	}
+	if top.Sort != "" {
+		result.Sort = top.Sort
+	}
+	if top.SortBy != "" {
+		result.SortBy = top.SortBy
+	}
+	result.RawLucene = s.RawLucene || top.RawLucene
+	result.CaseSensitive = s.CaseSensitive || top.CaseSensitive
+	appendStrings := func(a, b []string) []string {
+		joined := make([]string, 0, len(a)+len(b))
+		for _, s := range append(append(joined, a...), b...) {
+			if s != "" {
+				joined = append(joined, s)
+			}
+		}
+		return joined
+	}
+	result.Select = appendStrings(s.Select, top.Select)
+	result.Where = appendStrings(s.Where, top.Where)
+	result.QueryString = appendStrings(s.QueryString, top.QueryString)
+	result.Pattern = appendStrings(s.Pattern, top.Pattern)
+	result.ExcludePattern = appendStrings(s.ExcludePattern, top.ExcludePattern)
+	result.Not = appendStrings(s.Not, top.Not)
+	result.Extract = appendStrings(s.Extract, top.Extract)
+	result.RecordStart = appendStrings(s.RecordStart, top.RecordStart)
+	if top.Parse != "" {
+		result.Parse = top.Parse
+	}
+	result.Exclude = appendStrings(s.Exclude, top.Exclude)
+	result.Rename = appendStrings(s.Rename, top.Rename)
+	return result
+}
+
+// Returns a copy with f applied to all values
+func (s QuerySelectors) MapStrings(f func(string) string) QuerySelectors {
+	mapAll := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		mapped := make([]string, len(values))
+		for i, value := range values {
+			mapped[i] = f(value)
+		}
+		return mapped
+	}
+	return QuerySelectors{
+		Before:         f(s.Before),
+		After:          f(s.After),
+		Last:           f(s.Last),
+		Around:         f(s.Around),
+		Select:         mapAll(s.Select),
+		Where:          mapAll(s.Where),
+		Expression:     f(s.Expression),
+		Sort:           f(s.Sort),
+		SortBy:         f(s.SortBy),
+		QueryString:    mapAll(s.QueryString),
+		Pattern:        mapAll(s.Pattern),
+		ExcludePattern: mapAll(s.ExcludePattern),
+		RawLucene:      s.RawLucene,
+		Not:            mapAll(s.Not),
+		Extract:        mapAll(s.Extract),
+		Parse:          f(s.Parse),
+		RecordStart:    mapAll(s.RecordStart),
+		Exclude:        mapAll(s.Exclude),
+		Rename:         mapAll(s.Rename),
+		CaseSensitive:  s.CaseSensitive,
+	}
 }
 
 type LogMessage struct {
 	ID         string                 `json:"id,omitempty"`
 	Timestamp  time.Time              `json:"@timestamp"`
 	Attributes map[string]interface{} `json:"attributes"`
+	// Set for messages surrounding a match, rather than matching the query themselves
+	IsContext bool `json:"context,omitempty"`
 }
 
-// Performs a shallow copy of the Attributes map and adds fields for '@id' and '@timestamp'
+// Performs a shallow copy of the Attributes map and adds fields for '@id', '@timestamp'
+// and '@context' (for context messages)
 func (lm LogMessage) Map() map[string]interface{} {
 	out := make(map[string]interface{})
 	for k, v := range lm.Attributes {
@@ -55,6 +240,9 @@ func (lm LogMessage) Map() map[string]interface{} {
 	if lm.ID != "" {
 		out["@id"] = lm.ID
 	}
+	if lm.IsContext {
+		out["@context"] = true
+	}
 	out["@timestamp"] = lm.Timestamp.Format(TimeFormat)
 	return out
 }
@@ -132,6 +320,35 @@ func Project(m map[string]interface{}, fields []string) map[string]interface{} {
 	return projected
 }
 
+// Whether name matches any of the glob patterns
+func MatchesAnyGlob(name string, globs []string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Selects, excludes and renames attributes as the query asks for
+func (q Query) Project(m map[string]interface{}) map[string]interface{} {
+	projected := Project(m, q.SelectFields)
+	if len(q.ExcludeFields) == 0 && len(q.RenameFields) == 0 {
+		return projected
+	}
+	result := make(map[string]interface{}, len(projected))
+	for key, value := range projected {
+		if MatchesAnyGlob(key, q.ExcludeFields) {
+			continue
+		}
+		if newName, ok := q.RenameFields[key]; ok {
+			key = newName
+		}
+		result[key] = value
+	}
+	return result
+}
+
 func (f QueryFilter) Matches(m LogMessage) bool {
 	val, ok := m.Attributes[f.FieldName]
 	switch f.Operator {
@@ -139,27 +356,199 @@ func (f QueryFilter) Matches(m LogMessage) bool {
 		return ok && f.Value == fmt.Sprintf("%v", val)
 	case "!=":
 		return !ok || (ok && f.Value != fmt.Sprintf("%v", val))
+	case "<":
+		return ok && compareValues(val, f.Value) < 0
+	case "<=":
+		return ok && compareValues(val, f.Value) <= 0
+	case ">":
+		return ok && compareValues(val, f.Value) > 0
+	case ">=":
+		return ok && compareValues(val, f.Value) >= 0
+	case "=~":
+		return ok && f.compiledRegex().MatchString(fmt.Sprintf("%v", val))
+	case "!~":
+		return !ok || !f.compiledRegex().MatchString(fmt.Sprintf("%v", val))
+	case "in":
+		return ok && f.containsValue(fmt.Sprintf("%v", val))
+	case "notin":
+		return !ok || !f.containsValue(fmt.Sprintf("%v", val))
+	case "exists":
+		return ok
+	case "missing":
+		return !ok
 	default:
 		panic("Not supported operator")
 	}
 }
 
-func matchesPhrase(s, phrase string) bool {
-	return strings.Contains(strings.ToLower(s), strings.ToLower(phrase))
+var (
+	filterRegex        = regexp.MustCompile(`^([^!=<>]+)\s*(=~|!~|!=|<=|>=|=|<|>)\s*(.*)$`)
+	existsFilterRegex  = regexp.MustCompile(`^\s*([^!=<>?\s]+)\s*\?\s*$`)
+	missingFilterRegex = regexp.MustCompile(`^\s*!\s*([^!=<>?\s]+)\s*$`)
+	// The prefix is used by the expression tokenizer to recognize "field in (a, b)" as a
+	// single term. The operator has to be lower case, so free text like "logged IN (x)"
+	// isn't mistaken for a filter.
+	membershipFilterPrefixRegex = regexp.MustCompile(`^\s*([A-Za-z_@][\w.@-]*)\s+(in|notin|not\s+in)\s*\(([^)]*)\)`)
+	membershipFilterRegex       = regexp.MustCompile(membershipFilterPrefixRegex.String() + `\s*$`)
+)
+
+func isFilter(s string) bool {
+	return filterRegex.MatchString(s) || existsFilterRegex.MatchString(s) ||
+		missingFilterRegex.MatchString(s) || membershipFilterRegex.MatchString(s)
 }
 
-func MatchesQuery(m LogMessage, q Query) bool {
-	msg, _ := m.Attributes["message"].(string)
-	matchFound := matchesPhrase(msg, q.QueryString)
-	if q.QueryString != "" {
-		for _, v := range m.Attributes {
-			if vs, ok := v.(string); ok {
-				if matchesPhrase(vs, q.QueryString) {
-					matchFound = true
-				}
+// Splits a comma separated list of values, optionally quoted
+func splitValueList(s string) []string {
+	values := make([]string, 0, 5)
+	for _, value := range strings.Split(s, ",") {
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (f QueryFilter) containsValue(s string) bool {
+	for _, value := range f.Values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses filters of the shape used by --where, e.g. "level=error" or "status>=500",
+// "exception?" (attribute exists), "!user_id" (attribute is missing) or
+// "env in (prod,staging)" and "code notin (200,204)" (set membership)
+func ParseQueryFilter(s string) (QueryFilter, error) {
+	if matches := membershipFilterRegex.FindStringSubmatch(s); matches != nil {
+		operator := "in"
+		if matches[2] != "in" {
+			operator = "notin"
+		}
+		return NewQueryFilter(matches[1], operator, matches[3])
+	}
+	if matches := existsFilterRegex.FindStringSubmatch(s); matches != nil {
+		return NewQueryFilter(matches[1], "exists", "")
+	}
+	if matches := missingFilterRegex.FindStringSubmatch(s); matches != nil {
+		return NewQueryFilter(matches[1], "missing", "")
+	}
+	matches := filterRegex.FindStringSubmatch(s)
+	if matches == nil {
+		return QueryFilter{}, fmt.Errorf("Invalid filter: %s", s)
+	}
+	return NewQueryFilter(strings.TrimSpace(matches[1]), matches[2], matches[3])
+}
+
+// Formats the filter in the same syntax ParseQueryFilter accepts
+func (f QueryFilter) String() string {
+	switch f.Operator {
+	case "exists":
+		return fmt.Sprintf("%s?", f.FieldName)
+	case "missing":
+		return fmt.Sprintf("!%s", f.FieldName)
+	case "in", "notin":
+		return fmt.Sprintf("%s %s (%s)", f.FieldName, f.Operator, strings.Join(f.Values, ","))
+	default:
+		return fmt.Sprintf("%s%s%s", f.FieldName, f.Operator, f.Value)
+	}
+}
+
+// Filters built without NewQueryFilter have their pattern compiled on the fly
+func (f QueryFilter) compiledRegex() *regexp.Regexp {
+	if f.regex != nil {
+		return f.regex
+	}
+	return regexp.MustCompile(f.Value)
+}
+
+var comparableTimeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		for _, format := range comparableTimeFormats {
+			if ts, err := time.Parse(format, strings.TrimSpace(t)); err == nil {
+				return ts, true
 			}
 		}
 	}
+	return time.Time{}, false
+}
+
+// Compares an attribute value with a filter value: numerically if both are numbers,
+// chronologically if both are timestamps and lexically otherwise.
+// Returns -1, 0 or 1 like strings.Compare
+func compareValues(val interface{}, s string) int {
+	if a, ok := toFloat(val); ok {
+		if b, ok := toFloat(s); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	if a, ok := toTime(val); ok {
+		if b, ok := toTime(s); ok {
+			switch {
+			case a.Before(b):
+				return -1
+			case a.After(b):
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	return strings.Compare(fmt.Sprintf("%v", val), s)
+}
+
+func MatchesQuery(m LogMessage, q Query) bool {
+	return q.Matcher().Matches(m)
+}
+
+// Whether a message matches the text search, time range, filters and expression of the query
+func (qm QueryMatcher) Matches(m LogMessage) bool {
+	q := qm.query
+	matchFound := qm.MatchesText(m)
 	if q.Before != nil {
 		if m.Timestamp.After(*q.Before) {
 			return false
@@ -175,5 +564,8 @@ func MatchesQuery(m LogMessage, q Query) bool {
 			return false
 		}
 	}
+	if q.Expression != nil && !q.Expression.Matches(m, q.CaseSensitive) {
+		return false
+	}
 	return matchFound
 }
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -12,6 +13,8 @@ func TestFilter(t *testing.T) {
 			"message": "Sup",
 			"someStr": "Zef",
 			"someN":   34,
+			"someF":   "1.5",
+			"someTs":  "2017-09-04T11:49:24Z",
 		},
 	}
 	lastHour := time.Now().Add(-time.Hour)
@@ -51,6 +54,52 @@ func TestFilter(t *testing.T) {
 				QueryFilter{FieldName: "someNonexistingField", Value: "Pete", Operator: "!="},
 			},
 		},
+		Query{
+			Filters: []QueryFilter{
+				QueryFilter{FieldName: "someN", Value: "100", Operator: "<"},
+				QueryFilter{FieldName: "someN", Value: "34", Operator: "<="},
+				QueryFilter{FieldName: "someN", Value: "4", Operator: ">"},
+				QueryFilter{FieldName: "someN", Value: "34", Operator: ">="},
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				QueryFilter{FieldName: "someF", Value: "10", Operator: "<"},
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				QueryFilter{FieldName: "someTs", Value: "2017-09-04", Operator: ">"},
+				QueryFilter{FieldName: "someTs", Value: "2017-09-04T12:00:00+01:00", Operator: ">"},
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				QueryFilter{FieldName: "someStr", Value: "Aap", Operator: ">"},
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustNewQueryFilter("someStr", "=~", "^Z.f$"),
+				mustNewQueryFilter("someN", "=~", "3"),
+				mustNewQueryFilter("someStr", "!~", "Pete"),
+				mustNewQueryFilter("someNonexistingField", "!~", "Pete"),
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustParseQueryFilter("someStr?"),
+				mustParseQueryFilter("!someNonexistingField"),
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustParseQueryFilter("someStr in (Pete, Zef)"),
+				mustParseQueryFilter("someN in (33,34)"),
+				mustParseQueryFilter("someStr notin (Pete)"),
+				mustParseQueryFilter("someNonexistingField notin (Pete)"),
+			},
+		},
 	}
 	shouldNotMatchQueries := []Query{
 		Query{
@@ -67,6 +116,66 @@ func TestFilter(t *testing.T) {
 		Query{
 			After: &nextHour,
 		},
+		Query{
+			Filters: []QueryFilter{
+				QueryFilter{FieldName: "someN", Value: "4", Operator: "<"},
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				QueryFilter{FieldName: "someF", Value: "10", Operator: ">="},
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				QueryFilter{FieldName: "someTs", Value: "2017-09-05", Operator: ">"},
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				QueryFilter{FieldName: "someNonexistingField", Value: "10", Operator: "<"},
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustNewQueryFilter("someStr", "=~", "^ef"),
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustNewQueryFilter("someStr", "!~", "[Zz]ef"),
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustNewQueryFilter("someNonexistingField", "=~", ".*"),
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustParseQueryFilter("someNonexistingField?"),
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustParseQueryFilter("!someStr"),
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustParseQueryFilter("someStr in (Pete,zef)"),
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustParseQueryFilter("someN not in (34)"),
+			},
+		},
+		Query{
+			Filters: []QueryFilter{
+				mustParseQueryFilter("someNonexistingField in (Pete)"),
+			},
+		},
 	}
 	for i, shouldMatch := range shouldMatchQueries {
 		if !MatchesQuery(lm, shouldMatch) {
@@ -80,6 +189,56 @@ func TestFilter(t *testing.T) {
 	}
 }
 
+func mustNewQueryFilter(fieldName, operator, value string) QueryFilter {
+	filter, err := NewQueryFilter(fieldName, operator, value)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
+
+func mustParseQueryFilter(s string) QueryFilter {
+	filter, err := ParseQueryFilter(s)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
+
+func TestParseQueryFilter(t *testing.T) {
+	tests := map[string]QueryFilter{
+		"level=error":   QueryFilter{FieldName: "level", Operator: "=", Value: "error"},
+		"status >= 500": QueryFilter{FieldName: "status", Operator: ">=", Value: "500"},
+		"exception?":    QueryFilter{FieldName: "exception", Operator: "exists"},
+		"!user_id":      QueryFilter{FieldName: "user_id", Operator: "missing"},
+		"a!=b":          QueryFilter{FieldName: "a", Operator: "!=", Value: "b"},
+	}
+	for input, expected := range tests {
+		filter, err := ParseQueryFilter(input)
+		if err != nil {
+			t.Errorf("Could not parse %s: %v", input, err)
+			continue
+		}
+		if !reflect.DeepEqual(filter, expected) {
+			t.Errorf("Parsed %s as %+v, expected %+v", input, filter, expected)
+		}
+	}
+	for _, input := range []string{"level", "!", "?", "!a=b?"} {
+		if _, err := ParseQueryFilter(input); err == nil {
+			t.Errorf("Should not have parsed: %s", input)
+		}
+	}
+}
+
+func TestNewQueryFilter(t *testing.T) {
+	if _, err := NewQueryFilter("path", "=~", "^/api/(v2"); err == nil {
+		t.Error("Invalid regular expression should not be accepted")
+	}
+	if _, err := NewQueryFilter("path", "~~", "bla"); err != ErrorUnsupportedOperator {
+		t.Error("Invalid operator should not be accepted")
+	}
+}
+
 func TestFlatten(t *testing.T) {
 	into := make(map[string]interface{})
 	inputJsonString := `{
@@ -110,3 +269,71 @@ func TestFlatten(t *testing.T) {
 		t.Error("Didn't match")
 	}
 }
+
+func TestLayerQuerySelectors(t *testing.T) {
+	saved := QuerySelectors{
+		Last:        "1h",
+		Where:       []string{"level=error"},
+		Expression:  "service=api OR service=web",
+		Sort:        "asc",
+		QueryString: []string{"timeout"},
+		Not:         []string{"healthcheck"},
+	}
+	layered := saved.Layer(QuerySelectors{
+		After:         "yesterday",
+		Where:         []string{"env=prod"},
+		Expression:    "NOT host=canary",
+		QueryString:   []string{""},
+		Not:           []string{"canary"},
+		CaseSensitive: true,
+	})
+	expected := QuerySelectors{
+		After:          "yesterday",
+		Select:         []string{},
+		Where:          []string{"level=error", "env=prod"},
+		Expression:     "(service=api OR service=web) AND (NOT host=canary)",
+		Sort:           "asc",
+		QueryString:    []string{"timeout"},
+		Pattern:        []string{},
+		ExcludePattern: []string{},
+		Exclude:        []string{},
+		Rename:         []string{},
+		Not:            []string{"healthcheck", "canary"},
+		Extract:        []string{},
+		RecordStart:    []string{},
+		CaseSensitive:  true,
+	}
+	if !reflect.DeepEqual(layered, expected) {
+		t.Errorf("Wrong layered selectors: %+v", layered)
+	}
+}
+
+func TestQueryProject(t *testing.T) {
+	attributes := map[string]interface{}{
+		"message":               "Sup",
+		"log.level":             "info",
+		"kubernetes.pod":        "api-1",
+		"kubernetes.labels.app": "api",
+		"beat.version":          "6.2",
+	}
+	q := Query{
+		ExcludeFields: []string{"kubernetes.*", "beat.*"},
+		RenameFields:  map[string]string{"log.level": "level"},
+	}
+	expected := map[string]interface{}{
+		"message": "Sup",
+		"level":   "info",
+	}
+	if projected := q.Project(attributes); !reflect.DeepEqual(projected, expected) {
+		t.Errorf("Wrong projection: %+v", projected)
+	}
+	q.SelectFields = []string{"kubernetes.pod", "log.level"}
+	q.ExcludeFields = nil
+	expected = map[string]interface{}{
+		"kubernetes.pod": "api-1",
+		"level":          "info",
+	}
+	if projected := q.Project(attributes); !reflect.DeepEqual(projected, expected) {
+		t.Errorf("Wrong projection: %+v", projected)
+	}
+}
@@ -0,0 +1,375 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A boolean query expression, e.g. (level=error OR level=fatal) AND NOT "timeout"
+// Expressions are evaluated directly by the local backends and compiled into
+// native queries by others (e.g. Kibana). Phrases ignore case unless
+// caseSensitive, like query string terms.
+type Expression interface {
+	Matches(m LogMessage, caseSensitive bool) bool
+	String() string
+}
+
+type AndExpression struct {
+	Operands []Expression
+}
+
+type OrExpression struct {
+	Operands []Expression
+}
+
+type NotExpression struct {
+	Operand Expression
+}
+
+type FilterExpression struct {
+	Filter QueryFilter
+}
+
+type PhraseExpression struct {
+	Phrase string
+}
+
+func (e AndExpression) Matches(m LogMessage, caseSensitive bool) bool {
+	for _, operand := range e.Operands {
+		if !operand.Matches(m, caseSensitive) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e OrExpression) Matches(m LogMessage, caseSensitive bool) bool {
+	for _, operand := range e.Operands {
+		if operand.Matches(m, caseSensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e NotExpression) Matches(m LogMessage, caseSensitive bool) bool {
+	return !e.Operand.Matches(m, caseSensitive)
+}
+
+func (e FilterExpression) Matches(m LogMessage, caseSensitive bool) bool {
+	return e.Filter.Matches(m)
+}
+
+func (e PhraseExpression) Matches(m LogMessage, caseSensitive bool) bool {
+	return PhraseTerm(e.Phrase, caseSensitive).Matches(m)
+}
+
+// Names of the attributes an expression filters on
+func ExpressionFields(e Expression) []string {
+	switch expr := e.(type) {
+	case AndExpression:
+		return operandFields(expr.Operands)
+	case OrExpression:
+		return operandFields(expr.Operands)
+	case NotExpression:
+		return ExpressionFields(expr.Operand)
+	case FilterExpression:
+		return []string{expr.Filter.FieldName}
+	default:
+		return []string{}
+	}
+}
+
+func operandFields(operands []Expression) []string {
+	fields := make([]string, 0, len(operands))
+	for _, operand := range operands {
+		fields = append(fields, ExpressionFields(operand)...)
+	}
+	return fields
+}
+
+func joinExpressions(operands []Expression, operator string) string {
+	pieces := make([]string, 0, len(operands))
+	for _, operand := range operands {
+		switch operand.(type) {
+		case AndExpression, OrExpression:
+			pieces = append(pieces, fmt.Sprintf("(%s)", operand))
+		default:
+			pieces = append(pieces, operand.String())
+		}
+	}
+	return strings.Join(pieces, fmt.Sprintf(" %s ", operator))
+}
+
+func (e AndExpression) String() string {
+	return joinExpressions(e.Operands, "AND")
+}
+
+func (e OrExpression) String() string {
+	return joinExpressions(e.Operands, "OR")
+}
+
+func (e NotExpression) String() string {
+	switch e.Operand.(type) {
+	case AndExpression, OrExpression:
+		return fmt.Sprintf("NOT (%s)", e.Operand)
+	default:
+		return fmt.Sprintf("NOT %s", e.Operand)
+	}
+}
+
+func (e FilterExpression) String() string {
+	return e.Filter.String()
+}
+
+func (e PhraseExpression) String() string {
+	return fmt.Sprintf("%q", e.Phrase)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenPhrase
+	tokenOpen
+	tokenClose
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// Splits an expression into tokens. Words end at whitespace or at an unbalanced
+// closing parenthesis, so that regular expressions such as path=~^/(v1|v2)/ can
+// be used without quoting. Double quotes inside a word quote (part of) a value,
+// e.g. message="connection refused". Membership filters such as
+// "env in (prod, staging)" are kept together as a single word when they start
+// a term, so free text like "user logged in (admin)" stays free text.
+func tokenizeExpression(s string) ([]token, error) {
+	tokens := make([]token, 0, 10)
+	runes := []rune(s)
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenOpen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenClose, ")"})
+			i++
+		case r == '"':
+			phrase, next, err := readQuoted(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{tokenPhrase, phrase})
+			i = next
+		default:
+			if membership := membershipFilterPrefixRegex.FindString(string(runes[i:])); membership != "" && startsTerm(tokens) {
+				tokens = append(tokens, token{tokenWord, strings.TrimSpace(membership)})
+				i += len([]rune(membership))
+				continue
+			}
+			var word strings.Builder
+			depth := 0
+		wordLoop:
+			for i < len(runes) {
+				r = runes[i]
+				switch {
+				case unicode.IsSpace(r):
+					break wordLoop
+				case r == '"':
+					quoted, next, err := readQuoted(runes, i)
+					if err != nil {
+						return nil, err
+					}
+					word.WriteString(quoted)
+					i = next
+					continue
+				case r == '(':
+					depth++
+				case r == ')':
+					if depth == 0 {
+						break wordLoop
+					}
+					depth--
+				}
+				word.WriteRune(r)
+				i++
+			}
+			switch word.String() {
+			case "AND":
+				tokens = append(tokens, token{tokenAnd, "AND"})
+			case "OR":
+				tokens = append(tokens, token{tokenOr, "OR"})
+			case "NOT":
+				tokens = append(tokens, token{tokenNot, "NOT"})
+			default:
+				tokens = append(tokens, token{tokenWord, word.String()})
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// Whether the next token starts a term rather than continuing free text, i.e.
+// it follows an operator, a parenthesis or a filter
+func startsTerm(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind != tokenWord && last.kind != tokenPhrase || isFilter(last.value)
+}
+
+// Reads a double quoted string starting at runes[start], supporting \" and \\ escapes.
+// Returns the unquoted string and the index right after the closing quote.
+func readQuoted(runes []rune, start int) (string, int, error) {
+	var sb strings.Builder
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				sb.WriteRune(runes[i])
+			}
+		case '"':
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteRune(runes[i])
+		}
+	}
+	return "", 0, fmt.Errorf("Unterminated quote in expression: %s", string(runes[start:]))
+}
+
+type expressionParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *expressionParser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+// or := and (OR and)*
+func (p *expressionParser) parseOr() (Expression, error) {
+	operands := make([]Expression, 0, 2)
+	for {
+		operand, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		if t := p.peek(); t == nil || t.kind != tokenOr {
+			break
+		}
+		p.pos++
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return OrExpression{operands}, nil
+}
+
+// and := not (AND? not)*, adjacent terms are implicitly ANDed
+func (p *expressionParser) parseAnd() (Expression, error) {
+	operands := make([]Expression, 0, 2)
+	for {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		t := p.peek()
+		if t == nil || t.kind == tokenOr || t.kind == tokenClose {
+			break
+		}
+		if t.kind == tokenAnd {
+			p.pos++
+		}
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return AndExpression{operands}, nil
+}
+
+// not := NOT not | primary
+func (p *expressionParser) parseNot() (Expression, error) {
+	if t := p.peek(); t != nil && t.kind == tokenNot {
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return NotExpression{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// primary := "(" or ")" | phrase | filter | word
+func (p *expressionParser) parsePrimary() (Expression, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("Unexpected end of expression")
+	}
+	p.pos++
+	switch t.kind {
+	case tokenOpen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.peek(); t == nil || t.kind != tokenClose {
+			return nil, fmt.Errorf("Missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	case tokenPhrase:
+		return PhraseExpression{t.value}, nil
+	case tokenWord:
+		if isFilter(t.value) {
+			filter, err := ParseQueryFilter(t.value)
+			if err != nil {
+				return nil, err
+			}
+			return FilterExpression{filter}, nil
+		}
+		return PhraseExpression{t.value}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected %s in expression", t.value)
+	}
+}
+
+// Parses a boolean query expression. Supported are AND, OR and NOT (upper case),
+// grouping with parentheses, filters using the same syntax as --where and
+// (quoted) phrases. Terms without an operator in between are ANDed together.
+func ParseExpression(s string) (Expression, error) {
+	tokens, err := tokenizeExpression(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("Empty expression")
+	}
+	p := &expressionParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(tokens) {
+		return nil, fmt.Errorf("Unexpected %s in expression", tokens[p.pos].value)
+	}
+	return expr, nil
+}
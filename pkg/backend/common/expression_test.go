@@ -0,0 +1,112 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseExpression(t *testing.T) {
+	tests := map[string]string{
+		`(level=error OR level=fatal) AND NOT service=healthcheck AND "timeout"`: `(level=error OR level=fatal) AND NOT service=healthcheck AND "timeout"`,
+		`level=error level=fatal`:             `level=error AND level=fatal`,
+		`a OR b AND c`:                        `"a" OR ("b" AND "c")`,
+		`NOT (a OR b)`:                        `NOT ("a" OR "b")`,
+		`NOT NOT a`:                           `NOT NOT "a"`,
+		`path=~^/api/(v1|v2)/ OR status>=500`: `path=~^/api/(v1|v2)/ OR status>=500`,
+		`(path=~^/(v1|v2)/)`:                  `path=~^/(v1|v2)/`,
+		`message="connection refused" "said \"hello\""`: `message=connection refused AND "said \"hello\""`,
+		`level=error env in (prod, staging)`:            `level=error AND env in (prod,staging)`,
+		`(code not in (200))`:                           `code notin (200)`,
+		`user logged in (admin)`:                        `"user" AND "logged" AND "in" AND "admin"`,
+		`logged IN (admin)`:                             `"logged" AND "IN" AND "admin"`,
+	}
+	for input, expected := range tests {
+		expr, err := ParseExpression(input)
+		if err != nil {
+			t.Errorf("Could not parse %s: %v", input, err)
+			continue
+		}
+		if expr.String() != expected {
+			t.Errorf("Parsed %s as %s, expected %s", input, expr, expected)
+		}
+	}
+	invalid := []string{
+		``,
+		`(level=error`,
+		`level=error)`,
+		`level=error AND`,
+		`NOT`,
+		`"unterminated`,
+		`path=~(`,
+	}
+	for _, input := range invalid {
+		if _, err := ParseExpression(input); err == nil {
+			t.Errorf("Should not have parsed: %s", input)
+		}
+	}
+}
+
+func TestExpressionMatches(t *testing.T) {
+	lm := LogMessage{
+		Timestamp: time.Now(),
+		Attributes: map[string]interface{}{
+			"message": "Request timeout",
+			"level":   "error",
+			"service": "api",
+		},
+	}
+	shouldMatch := []string{
+		`(level=error OR level=fatal) AND NOT service=healthcheck AND "timeout"`,
+		`level=fatal OR service=api`,
+		`NOT level=fatal`,
+		`timeout`,
+	}
+	shouldNotMatch := []string{
+		`(level=warn OR level=fatal) AND "timeout"`,
+		`NOT service=api`,
+		`level=error "healthcheck"`,
+	}
+	for _, input := range shouldMatch {
+		if !MatchesQuery(lm, Query{Expression: mustParseExpression(input)}) {
+			t.Errorf("Did not match: %s", input)
+		}
+	}
+	for _, input := range shouldNotMatch {
+		if MatchesQuery(lm, Query{Expression: mustParseExpression(input)}) {
+			t.Errorf("Did match: %s", input)
+		}
+	}
+}
+
+func TestExpressionCaseSensitive(t *testing.T) {
+	lm := LogMessage{
+		Timestamp:  time.Now(),
+		Attributes: map[string]interface{}{"message": "Request Timeout"},
+	}
+	expr := mustParseExpression(`"request timeout" OR NOT "Timeout"`)
+	if !MatchesQuery(lm, Query{Expression: expr}) {
+		t.Error("Phrase should ignore case by default")
+	}
+	if MatchesQuery(lm, Query{Expression: expr, CaseSensitive: true}) {
+		t.Error("Phrase should be case sensitive with a case sensitive query")
+	}
+	if !MatchesQuery(lm, Query{Expression: mustParseExpression(`"Request Timeout"`), CaseSensitive: true}) {
+		t.Error("Phrase with matching case should match")
+	}
+}
+
+func TestExpressionFields(t *testing.T) {
+	fields := ExpressionFields(mustParseExpression(`(level=error OR status>=500) AND NOT "timeout" AND NOT host=canary`))
+	if !reflect.DeepEqual(fields, []string{"level", "status", "host"}) {
+		t.Errorf("Wrong fields: %v", fields)
+	}
+}
+
+func mustParseExpression(s string) Expression {
+	expr, err := ParseExpression(s)
+	if err != nil {
+		panic(err)
+	}
+	return expr
+}
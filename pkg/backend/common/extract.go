@@ -0,0 +1,181 @@
+package common
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Regular expressions with named groups, e.g. (?P<status>\d{3}), whose matches
+// in the message attribute are added as attributes. Extraction happens after
+// querying, so filters on extracted attributes are evaluated locally.
+type Extractors []*regexp.Regexp
+
+func ParseExtractor(s string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range regex.SubexpNames() {
+		if name != "" {
+			return regex, nil
+		}
+	}
+	return nil, errors.New("No named groups, use (?P<name>...)")
+}
+
+// Names of all attributes that can be extracted
+func (extractors Extractors) Fields() []string {
+	fields := make([]string, 0, len(extractors))
+	for _, regex := range extractors {
+		for _, name := range regex.SubexpNames() {
+			if name != "" {
+				fields = append(fields, name)
+			}
+		}
+	}
+	return fields
+}
+
+// Adds the named groups matched in the message attribute as attributes,
+// attributes that are already there are left alone
+func (extractors Extractors) Extract(m LogMessage) {
+	text, ok := m.Attributes["message"].(string)
+	if !ok {
+		return
+	}
+	for _, regex := range extractors {
+		matches := regex.FindStringSubmatch(text)
+		if matches == nil {
+			continue
+		}
+		for i, name := range regex.SubexpNames() {
+			if _, exists := m.Attributes[name]; name == "" || matches[i] == "" || exists {
+				continue
+			}
+			m.Attributes[name] = matches[i]
+		}
+	}
+}
+
+// Names of all attributes that can be extracted, as a set
+func (extractors Extractors) fieldSet() map[string]bool {
+	extracted := make(map[string]bool)
+	for _, field := range extractors.Fields() {
+		extracted[field] = true
+	}
+	return extracted
+}
+
+// Splits a query into the part to send to a backend and the part to evaluate
+// locally after extraction: filters, expressions and query string terms
+// referring to extracted attributes, and projection (which may need the
+// message attribute)
+func (extractors Extractors) SplitQuery(q Query) (Query, Query) {
+	extracted := extractors.fieldSet()
+	remote := q
+	remote.Filters = make([]QueryFilter, 0, len(q.Filters))
+	remote.SelectFields, remote.ExcludeFields, remote.RenameFields = nil, nil, nil
+	local := Query{
+		Filters:       make([]QueryFilter, 0, len(q.Filters)),
+		SelectFields:  q.SelectFields,
+		ExcludeFields: q.ExcludeFields,
+		RenameFields:  q.RenameFields,
+		CaseSensitive: q.CaseSensitive,
+	}
+	for _, filter := range q.Filters {
+		if extracted[filter.FieldName] {
+			local.Filters = append(local.Filters, filter)
+		} else {
+			remote.Filters = append(remote.Filters, filter)
+		}
+	}
+	if q.Expression != nil {
+		for _, field := range ExpressionFields(q.Expression) {
+			if extracted[field] {
+				remote.Expression, local.Expression = nil, q.Expression
+				break
+			}
+		}
+	}
+	if !q.RawQueryString {
+		// The backend doesn't know extracted attributes, so field:term terms on them never match there
+		remotePieces := make([]string, 0, 4)
+		localPieces := make([]string, 0, 4)
+		for _, piece := range splitQueryString(q.QueryString) {
+			if extracted[parseQueryTerm(piece, q.CaseSensitive).Field] {
+				localPieces = append(localPieces, piece)
+			} else {
+				remotePieces = append(remotePieces, piece)
+			}
+		}
+		remote.QueryString = strings.Join(remotePieces, " ")
+		local.QueryString = strings.Join(localPieces, " ")
+	}
+	return remote, local
+}
+
+// Extracts attributes from messages as they come in
+func (extractors Extractors) extract(messages <-chan LogMessage) <-chan LogMessage {
+	resultChan := make(chan LogMessage)
+	go func() {
+		for message := range messages {
+			extractors.Extract(message)
+			resultChan <- message
+		}
+		close(resultChan)
+	}()
+	return resultChan
+}
+
+// Queries a client, extracting attributes from the results before filtering,
+// sorting and projecting them with the local part of the query. Messages
+// dropped by local filters would count towards the limit of a backend that
+// limits results itself, so the remote query is unlimited then and read until
+// q.MaxResults messages matched. Local inputs return every message, of which
+// the last q.MaxResults matches are kept. Sorting on an extracted attribute
+// happens locally on all matches.
+func (extractors Extractors) Query(client Client, q Query) <-chan LogMessage {
+	remote, local := extractors.SplitQuery(q)
+	matcher := local.Matcher()
+	filtered := len(local.Filters) > 0 || local.Expression != nil || local.QueryString != ""
+	limiter, ok := client.(ResultLimiter)
+	limitsResults := ok && limiter.LimitsResults()
+	sortQuery := Query{
+		SortField:  q.SortField,
+		SortOrder:  q.SortOrder,
+		MaxResults: q.MaxResults,
+		Follow:     q.Follow,
+	}
+	var matched <-chan LogMessage
+	switch {
+	case extractors.fieldSet()[q.SortField] || (filtered && !limitsResults && q.sortField() != "@timestamp"):
+		remote = remote.Unsorted()
+		remote.MaxResults = 0
+		matched = SortMessages(FirstMatches(extractors.extract(client.Query(remote)), matcher.Matches, 0, false), sortQuery)
+	case filtered && q.MaxResults > 0 && !q.Follow && limitsResults:
+		remote.MaxResults = 0
+		reverse := false
+		if q.sortField() == "@timestamp" && q.SortOrder != "desc" {
+			// The most recent matches are wanted, but returned oldest first
+			remote.SortOrder = "desc"
+			reverse = true
+		}
+		matched = FirstMatches(extractors.extract(client.Query(remote)), matcher.Matches, q.MaxResults, reverse)
+	case filtered && q.MaxResults > 0 && !q.Follow:
+		remote = remote.Unsorted()
+		remote.MaxResults = 0
+		matched = SortMessages(LastMatches(extractors.extract(client.Query(remote)), matcher.Matches, q.MaxResults), sortQuery)
+	default:
+		matched = FirstMatches(extractors.extract(client.Query(remote)), matcher.Matches, 0, false)
+	}
+	resultChan := make(chan LogMessage)
+	go func() {
+		for message := range matched {
+			message.Attributes = local.Project(message.Attributes)
+			resultChan <- message
+		}
+		close(resultChan)
+	}()
+	return resultChan
+}
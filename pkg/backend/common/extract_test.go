@@ -0,0 +1,189 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type messagesClient []LogMessage
+
+func (client messagesClient) Query(q Query) <-chan LogMessage {
+	resultChan := make(chan LogMessage)
+	go func() {
+		for _, message := range client {
+			if MatchesQuery(message, q) {
+				resultChan <- message
+			}
+		}
+		close(resultChan)
+	}()
+	return resultChan
+}
+
+func mustParseExtractor(s string) Extractors {
+	extractor, err := ParseExtractor(s)
+	if err != nil {
+		panic(err)
+	}
+	return Extractors{extractor}
+}
+
+func TestParseExtractor(t *testing.T) {
+	for _, s := range []string{`(GET|POST) \S+`, `(?P<status>\d{3}`} {
+		if _, err := ParseExtractor(s); err == nil {
+			t.Errorf("Should not have parsed: %s", s)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	extractors := mustParseExtractor(`(?P<method>GET|POST) (?P<path>\S+) (?P<status>\d{3})(?: (?P<duration>\d+)ms)?`)
+	if fields := extractors.Fields(); !reflect.DeepEqual(fields, []string{"method", "path", "status", "duration"}) {
+		t.Errorf("Wrong fields: %v", fields)
+	}
+	m := LogMessage{Attributes: map[string]interface{}{
+		"message": "Handled GET /api/users 503",
+		"method":  "HEAD",
+	}}
+	extractors.Extract(m)
+	expected := map[string]interface{}{
+		"message": "Handled GET /api/users 503",
+		"method":  "HEAD",
+		"path":    "/api/users",
+		"status":  "503",
+	}
+	if !reflect.DeepEqual(m.Attributes, expected) {
+		t.Errorf("Wrong attributes: %+v", m.Attributes)
+	}
+}
+
+func TestExtractorsQuery(t *testing.T) {
+	client := messagesClient{
+		{Attributes: map[string]interface{}{"message": "GET /a 200", "service": "api"}},
+		{Attributes: map[string]interface{}{"message": "GET /b 503", "service": "api"}},
+		{Attributes: map[string]interface{}{"message": "POST /c 500", "service": "web"}},
+		{Attributes: map[string]interface{}{"message": "Starting up", "service": "api"}},
+	}
+	extractors := mustParseExtractor(`(?P<method>GET|POST) (?P<path>\S+) (?P<status>\d{3})`)
+	q := Query{
+		Filters: []QueryFilter{
+			mustParseQueryFilter("status>=500"),
+			mustParseQueryFilter("service=api"),
+		},
+		SelectFields: []string{"path", "status"},
+	}
+	remote, local := extractors.SplitQuery(q)
+	if len(remote.Filters) != 1 || len(local.Filters) != 1 || remote.SelectFields != nil {
+		t.Errorf("Wrong split: %+v %+v", remote, local)
+	}
+	results := make([]map[string]interface{}, 0)
+	for message := range extractors.Query(client, q) {
+		results = append(results, message.Attributes)
+	}
+	expected := []map[string]interface{}{
+		{"path": "/b", "status": "503"},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Wrong results: %+v", results)
+	}
+}
+
+// Returns the q.MaxResults most recent matches like Kibana does, remembering the query
+type recentClient struct {
+	messages []LogMessage
+	queries  []Query
+}
+
+func (client *recentClient) Query(q Query) <-chan LogMessage {
+	client.queries = append(client.queries, q)
+	matches := make([]LogMessage, 0, len(client.messages))
+	for _, message := range client.messages {
+		if MatchesQuery(message, q) {
+			matches = append(matches, message)
+		}
+	}
+	if q.MaxResults > 0 && len(matches) > q.MaxResults {
+		matches = matches[len(matches)-q.MaxResults:]
+	}
+	resultChan := make(chan LogMessage)
+	go func() {
+		for i := range matches {
+			if q.SortOrder == "desc" {
+				i = len(matches) - 1 - i
+			}
+			resultChan <- matches[i]
+		}
+		close(resultChan)
+	}()
+	return resultChan
+}
+
+func (client *recentClient) LimitsResults() bool {
+	return true
+}
+
+func TestExtractorsQueryLimit(t *testing.T) {
+	client := &recentClient{}
+	for i, line := range []string{"GET /a 503", "GET /b 200", "GET /c 500", "GET /d 200", "GET /e 502", "GET /f 200"} {
+		client.messages = append(client.messages, LogMessage{
+			Timestamp:  time.Date(2017, 10, 17, 14, i, 0, 0, time.UTC),
+			Attributes: map[string]interface{}{"message": line},
+		})
+	}
+	extractors := mustParseExtractor(`(?P<method>GET|POST) (?P<path>\S+) (?P<status>\d{3})`)
+	paths := func(q Query) []interface{} {
+		results := make([]interface{}, 0)
+		for message := range extractors.Query(client, q) {
+			results = append(results, message.Attributes["path"])
+		}
+		return results
+	}
+
+	q := Query{QueryString: "GET status:50*", Filters: []QueryFilter{mustParseQueryFilter("status>=500")}, MaxResults: 2}
+	if results := paths(q); !reflect.DeepEqual(results, []interface{}{"/c", "/e"}) {
+		t.Errorf("Expected the 2 most recent matches, oldest first: %v", results)
+	}
+	if remote := client.queries[len(client.queries)-1]; remote.QueryString != "GET" || remote.MaxResults != 0 {
+		t.Errorf("Wrong remote query: %+v", remote)
+	}
+
+	q = Query{Filters: []QueryFilter{mustParseQueryFilter("status>=500")}, MaxResults: 2, SortOrder: "desc"}
+	if results := paths(q); !reflect.DeepEqual(results, []interface{}{"/e", "/c"}) {
+		t.Errorf("Expected the 2 most recent matches, newest first: %v", results)
+	}
+
+	q = Query{MaxResults: 3, SortField: "path", SortOrder: "desc"}
+	if results := paths(q); !reflect.DeepEqual(results, []interface{}{"/f", "/e", "/d"}) {
+		t.Errorf("Expected sorting on the extracted attribute: %v", results)
+	}
+	if remote := client.queries[len(client.queries)-1]; remote.IsSorted() {
+		t.Errorf("Should not sort remotely on an extracted attribute: %+v", remote)
+	}
+}
+
+func TestExtractorsQueryLocalLimit(t *testing.T) {
+	client := make(messagesClient, 0, 25000)
+	for i := 0; i < 25000; i++ {
+		client = append(client, LogMessage{
+			Timestamp:  time.Date(2017, 10, 17, 14, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Second),
+			Attributes: map[string]interface{}{"message": fmt.Sprintf("GET /%d %d", i, 200+i%2*300)},
+		})
+	}
+	extractors := mustParseExtractor(`(?P<method>GET|POST) (?P<path>\S+) (?P<status>\d{3})`)
+	for _, sortOrder := range []string{"", "desc"} {
+		q := Query{Filters: []QueryFilter{mustParseQueryFilter("status>=500")}, MaxResults: 3, SortOrder: sortOrder}
+		results := make([]interface{}, 0)
+		for message := range extractors.Query(client, q) {
+			results = append(results, message.Attributes["path"])
+		}
+		expected := []interface{}{"/24995", "/24997", "/24999"}
+		if sortOrder == "desc" {
+			expected = []interface{}{"/24999", "/24997", "/24995"}
+		}
+		if !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected the 3 most recent matches (sort %q): %v", sortOrder, results)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package common
+
+// Reads messages until limit of them matched (no limit if 0), sending them in
+// reverse order when reverse is set. The remaining messages are drained in the
+// background, so that the client producing them isn't left blocked. Context
+// messages are passed on regardless of matches and don't count towards the limit.
+// Used when messages are filtered locally, after a backend that limits results
+// itself was asked for all of them.
+func FirstMatches(messages <-chan LogMessage, matches func(LogMessage) bool, limit int, reverse bool) <-chan LogMessage {
+	resultChan := make(chan LogMessage)
+	go func() {
+		kept := make([]LogMessage, 0)
+		matchCount := 0
+		for message := range messages {
+			if !message.IsContext {
+				if !matches(message) {
+					continue
+				}
+				if limit > 0 && matchCount >= limit {
+					go func() {
+						for range messages {
+						}
+					}()
+					break
+				}
+				matchCount++
+			}
+			if reverse {
+				kept = append(kept, message)
+			} else {
+				resultChan <- message
+			}
+		}
+		for i := len(kept) - 1; i >= 0; i-- {
+			resultChan <- kept[i]
+		}
+		close(resultChan)
+	}()
+	return resultChan
+}
+
+// Keeps the last limit messages that match, along with the context messages
+// that came before each of them (and after the last one), for local inputs
+// that return every message in the order they were logged
+func LastMatches(messages <-chan LogMessage, matches func(LogMessage) bool, limit int) <-chan LogMessage {
+	resultChan := make(chan LogMessage)
+	go func() {
+		// Ring buffer of matches with the context messages before them
+		ring := make([][]LogMessage, limit)
+		next, matchCount := 0, 0
+		context := make([]LogMessage, 0)
+		for message := range messages {
+			if message.IsContext {
+				context = append(context, message)
+				continue
+			}
+			if !matches(message) {
+				continue
+			}
+			ring[next] = append(context, message)
+			context = make([]LogMessage, 0)
+			next = (next + 1) % limit
+			matchCount++
+		}
+		first := 0
+		if matchCount >= limit {
+			first = next
+		} else {
+			limit = matchCount
+		}
+		for i := 0; i < limit; i++ {
+			for _, message := range ring[(first+i)%len(ring)] {
+				resultChan <- message
+			}
+		}
+		for _, message := range context {
+			resultChan <- message
+		}
+		close(resultChan)
+	}()
+	return resultChan
+}
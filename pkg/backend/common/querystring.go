@@ -0,0 +1,255 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Query strings are matched the same way by all backends:
+//
+//   - The query string consists of terms separated by whitespace, all of which
+//     have to match (e.g. timeout database).
+//   - A term matches whole words, so error matches "an error occurred" but not
+//     "errors". Words are sequences of letters, digits and underscores.
+//   - A quoted phrase ("connection refused") matches its words in this order
+//     and next to each other. Unquoted terms containing punctuation (db-1) are
+//     treated as phrases too.
+//   - The wildcards * and ? can be used within a word (time*). Wildcard terms
+//     containing punctuation (db-*) are split into words like phrases are.
+//   - Matching ignores case, unless the query is case sensitive.
+//   - A term can be limited to a single attribute with field:term or
+//     field:"some phrase". Otherwise it matches any string attribute.
+//
+// This mimics full text search in Elasticsearch with the standard analyzer.
+type QueryTerm struct {
+	Field string
+	// Words of the term (lower case unless CaseSensitive), globs for wildcard terms
+	Words         []string
+	Wildcard      bool
+	CaseSensitive bool
+}
+
+var fieldTermRegex = regexp.MustCompile(`^([\p{L}_@][\p{L}\p{N}_@.\-]*):(.+)$`)
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// Splits a string into words, lower cased unless caseSensitive
+func splitWords(s string, caseSensitive bool) []string {
+	if !caseSensitive {
+		s = strings.ToLower(s)
+	}
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+// Splits a wildcard term into words like splitWords, keeping the wildcards
+func splitPattern(s string, caseSensitive bool) []string {
+	if !caseSensitive {
+		s = strings.ToLower(s)
+	}
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !isWordRune(r) && r != '*' && r != '?'
+	})
+}
+
+// Splits a query string on whitespace, keeping quoted phrases (which may be
+// prefixed with field:) together. Quotes are kept, so phrases can be recognized.
+func splitQueryString(s string) []string {
+	pieces := make([]string, 0, 4)
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				pieces = append(pieces, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		pieces = append(pieces, current.String())
+	}
+	return pieces
+}
+
+// Parses a single term, see QueryTerm
+func ParseQueryTerm(s string) QueryTerm {
+	return parseQueryTerm(s, false)
+}
+
+func parseQueryTerm(s string, caseSensitive bool) QueryTerm {
+	term := QueryTerm{CaseSensitive: caseSensitive}
+	if matches := fieldTermRegex.FindStringSubmatch(s); matches != nil && !strings.HasPrefix(matches[2], "/") {
+		// Values starting with a slash are more likely URLs than field scoped terms
+		term.Field = matches[1]
+		s = matches[2]
+	}
+	if strings.HasPrefix(s, `"`) {
+		term.Words = splitWords(strings.Trim(s, `"`), caseSensitive)
+		return term
+	}
+	if strings.ContainsAny(s, "*?") {
+		term.Wildcard = true
+		term.Words = splitPattern(s, caseSensitive)
+		return term
+	}
+	term.Words = splitWords(s, caseSensitive)
+	return term
+}
+
+// A term matching a literal phrase in any attribute
+func PhraseTerm(phrase string, caseSensitive bool) QueryTerm {
+	return QueryTerm{Words: splitWords(phrase, caseSensitive), CaseSensitive: caseSensitive}
+}
+
+func ParseQueryString(s string) []QueryTerm {
+	return parseQueryString(s, false)
+}
+
+func parseQueryString(s string, caseSensitive bool) []QueryTerm {
+	pieces := splitQueryString(s)
+	terms := make([]QueryTerm, 0, len(pieces))
+	for _, piece := range pieces {
+		term := parseQueryTerm(piece, caseSensitive)
+		if len(term.Words) > 0 {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// The term as it would be written in a query string
+func (t QueryTerm) String() string {
+	s := strings.Join(t.Words, " ")
+	if !t.Wildcard {
+		s = fmt.Sprintf("%q", s)
+	}
+	if t.Field != "" {
+		return fmt.Sprintf("%s:%s", t.Field, s)
+	}
+	return s
+}
+
+func (t QueryTerm) matchesWord(pattern, word string) bool {
+	if t.Wildcard {
+		ok, _ := path.Match(pattern, word)
+		return ok
+	}
+	return pattern == word
+}
+
+func (t QueryTerm) matchesWords(words []string) bool {
+	for i := 0; i+len(t.Words) <= len(words); i++ {
+		matches := true
+		for j, word := range t.Words {
+			if !t.matchesWord(word, words[i+j]) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func (t QueryTerm) matchesValue(v interface{}) bool {
+	switch value := v.(type) {
+	case string:
+		return t.matchesWords(splitWords(value, t.CaseSensitive))
+	case nil, map[string]interface{}, []interface{}:
+		return false
+	default:
+		// Numbers and booleans are only searched when asked for explicitly
+		return t.Field != "" && t.matchesWords(splitWords(fmt.Sprintf("%v", value), t.CaseSensitive))
+	}
+}
+
+func (t QueryTerm) Matches(m LogMessage) bool {
+	if len(t.Words) == 0 {
+		return true
+	}
+	if t.Field != "" {
+		return t.matchesValue(m.Attributes[t.Field])
+	}
+	for _, v := range m.Attributes {
+		if t.matchesValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a message matches all terms of a query string
+func MatchesQueryString(m LogMessage, s string) bool {
+	for _, term := range ParseQueryString(s) {
+		if !term.Matches(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// The terms of the query string, which all have to match. Use Matcher to
+// match many messages.
+func (q Query) Terms() []QueryTerm {
+	return parseQueryString(q.QueryString, q.CaseSensitive)
+}
+
+// The excluded phrases as terms, none of which may match
+func (q Query) ExcludedTerms() []QueryTerm {
+	terms := make([]QueryTerm, 0, len(q.ExcludePhrases))
+	for _, phrase := range q.ExcludePhrases {
+		term := PhraseTerm(phrase, q.CaseSensitive)
+		if len(term.Words) > 0 {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// A query with its query string and excluded phrases parsed, to match many
+// messages without parsing them again for each
+type QueryMatcher struct {
+	query         Query
+	terms         []QueryTerm
+	excludedTerms []QueryTerm
+}
+
+func (q Query) Matcher() QueryMatcher {
+	return QueryMatcher{query: q, terms: q.Terms(), excludedTerms: q.ExcludedTerms()}
+}
+
+// Whether a message matches the text search of the query: all terms of the
+// query string and none of the excluded phrases
+func (qm QueryMatcher) MatchesText(m LogMessage) bool {
+	for _, term := range qm.terms {
+		if !term.Matches(m) {
+			return false
+		}
+	}
+	for _, term := range qm.excludedTerms {
+		if term.Matches(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether a message matches the text search of a query, see QueryMatcher.MatchesText
+func MatchesText(m LogMessage, q Query) bool {
+	return q.Matcher().MatchesText(m)
+}
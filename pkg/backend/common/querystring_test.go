@@ -0,0 +1,97 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQueryString(t *testing.T) {
+	terms := ParseQueryString(`timeout  "Connection refused" service:api msg:"db down" time* db-1 db-* "" http://x`)
+	expected := []QueryTerm{
+		{Words: []string{"timeout"}},
+		{Words: []string{"connection", "refused"}},
+		{Field: "service", Words: []string{"api"}},
+		{Field: "msg", Words: []string{"db", "down"}},
+		{Words: []string{"time*"}, Wildcard: true},
+		{Words: []string{"db", "1"}},
+		{Words: []string{"db", "*"}, Wildcard: true},
+		{Words: []string{"http", "x"}},
+	}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("Wrong terms: %+v", terms)
+	}
+}
+
+func TestMatchesQueryString(t *testing.T) {
+	lm := LogMessage{
+		Attributes: map[string]interface{}{
+			"message": "Connection to db-1 refused, retrying in 5s",
+			"service": "API",
+			"status":  503,
+		},
+	}
+	shouldMatch := []string{
+		``,
+		`connection`,
+		`CONNECTION refused`,
+		`"connection to db-1"`,
+		`db-1`,
+		`retry*`,
+		`ret?ying`,
+		`db-*`,
+		`to-db-?`,
+		`service:api`,
+		`message:"refused retrying"`,
+		`status:503`,
+	}
+	shouldNotMatch := []string{
+		`conn`,
+		`"refused connection"`,
+		`connection timeout`,
+		`retry`,
+		`db-2*`,
+		`service:connection`,
+		`503`,
+		`missing:api`,
+	}
+	for _, s := range shouldMatch {
+		if !MatchesQueryString(lm, s) {
+			t.Errorf("Did not match: %s", s)
+		}
+	}
+	for _, s := range shouldNotMatch {
+		if MatchesQueryString(lm, s) {
+			t.Errorf("Did match: %s", s)
+		}
+	}
+}
+
+func TestMatchesText(t *testing.T) {
+	lm := LogMessage{
+		Attributes: map[string]interface{}{
+			"message": "Timeout in HealthCheck for db-1",
+		},
+	}
+	shouldMatch := []Query{
+		{QueryString: "timeout", ExcludePhrases: []string{"connection refused"}},
+		{QueryString: "Timeout", CaseSensitive: true},
+		{QueryString: "Timeout", ExcludePhrases: []string{"healthcheck"}, CaseSensitive: true},
+		{ExcludePhrases: []string{"", "db 2"}},
+	}
+	shouldNotMatch := []Query{
+		{QueryString: "timeout", ExcludePhrases: []string{"healthcheck"}},
+		{ExcludePhrases: []string{"connection refused", "HEALTHCHECK for db-1"}},
+		{QueryString: "timeout", CaseSensitive: true},
+		{QueryString: "health*", CaseSensitive: true},
+	}
+	for i, q := range shouldMatch {
+		if !MatchesText(lm, q) {
+			t.Errorf("Did not match: %d: %+v", i, q)
+		}
+	}
+	for i, q := range shouldNotMatch {
+		if MatchesText(lm, q) {
+			t.Errorf("Did match: %d: %+v", i, q)
+		}
+	}
+}
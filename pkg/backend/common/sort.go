@@ -0,0 +1,141 @@
+package common
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Upper bound on the number of messages kept in memory for sorting when
+// following or when the number of results is unlimited, beyond this results
+// are sorted in batches
+const maxSortBuffer = 10000
+
+func (q Query) IsSorted() bool {
+	return q.SortOrder != "" || q.SortField != ""
+}
+
+func (q Query) sortField() string {
+	if q.SortField == "" {
+		return "@timestamp"
+	}
+	return q.SortField
+}
+
+// Returns a copy of the query without sorting, used by backends that sort
+// the merged results of multiple underlying queries themselves
+func (q Query) Unsorted() Query {
+	q.SortOrder = ""
+	q.SortField = ""
+	return q
+}
+
+// Returns whether message a comes before b when sorting on an attribute (or
+// @timestamp), messages missing the attribute always come last
+func messageBefore(a, b LogMessage, field string, desc bool) bool {
+	var cmp int
+	if field == "@timestamp" {
+		switch {
+		case a.Timestamp.Before(b.Timestamp):
+			cmp = -1
+		case a.Timestamp.After(b.Timestamp):
+			cmp = 1
+		}
+	} else {
+		aVal, aOk := a.Attributes[field]
+		bVal, bOk := b.Attributes[field]
+		if !aOk || !bOk {
+			return aOk && !bOk
+		}
+		cmp = compareValues(aVal, fmt.Sprintf("%v", bVal))
+	}
+	if desc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// Heap of messages with the first one to drop when the buffer is full at the top
+type messageHeap struct {
+	messages []LogMessage
+	dropLess func(a, b LogMessage) bool
+}
+
+func (h *messageHeap) Len() int           { return len(h.messages) }
+func (h *messageHeap) Less(i, j int) bool { return h.dropLess(h.messages[i], h.messages[j]) }
+func (h *messageHeap) Swap(i, j int)      { h.messages[i], h.messages[j] = h.messages[j], h.messages[i] }
+func (h *messageHeap) Push(x interface{}) { h.messages = append(h.messages, x.(LogMessage)) }
+func (h *messageHeap) Pop() interface{} {
+	last := h.messages[len(h.messages)-1]
+	h.messages = h.messages[:len(h.messages)-1]
+	return last
+}
+
+// Sorts messages for backends that can't sort natively (stream, docker etc.)
+// using a bounded buffer. The first q.MaxResults messages in the requested
+// order are returned, which for @timestamp are the q.MaxResults most recent
+// ones, like with Kibana. In follow mode and when the number of results is
+// unlimited, messages are sorted in batches of maxSortBuffer.
+func SortMessages(messages <-chan LogMessage, q Query) <-chan LogMessage {
+	if !q.IsSorted() {
+		return messages
+	}
+	field := q.sortField()
+	desc := q.SortOrder == "desc"
+	bufferSize := q.MaxResults
+	batched := bufferSize <= 0 || q.Follow
+	if batched {
+		bufferSize = maxSortBuffer
+	}
+	buffer := &messageHeap{
+		messages: make([]LogMessage, 0, bufferSize),
+		dropLess: func(a, b LogMessage) bool {
+			if field == "@timestamp" {
+				return a.Timestamp.Before(b.Timestamp)
+			}
+			return messageBefore(b, a, field, desc)
+		},
+	}
+	resultChan := make(chan LogMessage)
+	flush := func() {
+		sorted := buffer.messages
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return messageBefore(sorted[i], sorted[j], field, desc)
+		})
+		for _, message := range sorted {
+			resultChan <- message
+		}
+		buffer.messages = make([]LogMessage, 0, bufferSize)
+	}
+	go func() {
+		var tick <-chan time.Time
+		if q.Follow {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case message, ok := <-messages:
+				if !ok {
+					flush()
+					close(resultChan)
+					return
+				}
+				if buffer.Len() < bufferSize {
+					heap.Push(buffer, message)
+				} else if batched {
+					flush()
+					heap.Push(buffer, message)
+				} else if buffer.dropLess(buffer.messages[0], message) {
+					buffer.messages[0] = message
+					heap.Fix(buffer, 0)
+				}
+			case <-tick:
+				flush()
+			}
+		}
+	}()
+	return resultChan
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func sortTestMessages(q Query) []LogMessage {
+	start := time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)
+	durations := []float64{30, 10, 50, 20, 40}
+	messages := make(chan LogMessage)
+	go func() {
+		for i, duration := range durations {
+			messages <- LogMessage{
+				Timestamp:  start.Add(time.Duration(i) * time.Minute),
+				Attributes: map[string]interface{}{"duration": duration},
+			}
+		}
+		messages <- LogMessage{
+			Timestamp:  start.Add(time.Hour),
+			Attributes: map[string]interface{}{},
+		}
+		close(messages)
+	}()
+	results := make([]LogMessage, 0, 6)
+	for message := range SortMessages(messages, q) {
+		results = append(results, message)
+	}
+	return results
+}
+
+func TestSortMessages(t *testing.T) {
+	tests := []struct {
+		query    Query
+		expected []interface{}
+	}{
+		// Unsorted, everything passes in order
+		{Query{MaxResults: 3}, []interface{}{30.0, 10.0, 50.0, 20.0, 40.0, nil}},
+		// Most recent 3, newest first
+		{Query{MaxResults: 3, SortOrder: "desc"}, []interface{}{nil, 40.0, 20.0}},
+		// Most recent 3, oldest first
+		{Query{MaxResults: 3, SortOrder: "asc"}, []interface{}{20.0, 40.0, nil}},
+		// Unlimited, newest first
+		{Query{SortOrder: "desc"}, []interface{}{nil, 40.0, 20.0, 50.0, 10.0, 30.0}},
+		// Largest 2
+		{Query{MaxResults: 2, SortOrder: "desc", SortField: "duration"}, []interface{}{50.0, 40.0}},
+		// Smallest 2
+		{Query{MaxResults: 2, SortField: "duration"}, []interface{}{10.0, 20.0}},
+		// Unlimited, missing values last
+		{Query{SortField: "duration"}, []interface{}{10.0, 20.0, 30.0, 40.0, 50.0, nil}},
+	}
+	for _, test := range tests {
+		results := sortTestMessages(test.query)
+		if len(results) != len(test.expected) {
+			t.Errorf("Expected %d results for %+v, got %d", len(test.expected), test.query, len(results))
+			continue
+		}
+		for i, message := range results {
+			if message.Attributes["duration"] != test.expected[i] {
+				t.Errorf("Expected %v at position %d for %+v, got %v", test.expected[i], i, test.query, message.Attributes["duration"])
+			}
+		}
+	}
+}
+
+func TestSortMessagesBeyondBuffer(t *testing.T) {
+	start := time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)
+	messages := make(chan LogMessage)
+	go func() {
+		for i := 0; i < 2*maxSortBuffer+5000; i++ {
+			messages <- LogMessage{Timestamp: start.Add(time.Duration(i) * time.Second)}
+		}
+		close(messages)
+	}()
+	results := make([]time.Time, 0, 3)
+	for message := range SortMessages(messages, Query{MaxResults: 3, SortOrder: "desc"}) {
+		results = append(results, message.Timestamp)
+	}
+	last := start.Add(time.Duration(2*maxSortBuffer+5000-1) * time.Second)
+	if len(results) != 3 || !results[0].Equal(last) || !results[2].Equal(last.Add(-2*time.Second)) {
+		t.Errorf("Expected the 3 most recent messages, got %v", results)
+	}
+}
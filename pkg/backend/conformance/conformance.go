@@ -0,0 +1,103 @@
+// Package conformance contains tests every common.Client implementation has
+// to pass, so that queries give the same results regardless of the backend.
+package conformance
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+var start = time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)
+
+func message(minute int, attributes map[string]interface{}) common.LogMessage {
+	ts := start.Add(time.Duration(minute) * time.Minute)
+	attributes["@timestamp"] = ts.Format(time.RFC3339)
+	return common.LogMessage{
+		Timestamp:  ts,
+		Attributes: attributes,
+	}
+}
+
+// The messages clients are tested with, backends that can't be fed messages
+// directly (e.g. Kibana) need to have these indexed up front
+var Messages = []common.LogMessage{
+	message(0, map[string]interface{}{"message": "Connection to db-1 refused", "service": "api", "level": "error", "status": 503.0}),
+	message(1, map[string]interface{}{"message": "Connection to db-2 timed out after 3000 ms", "service": "api", "level": "warn", "status": 504.0}),
+	message(2, map[string]interface{}{"message": "User alice logged in", "service": "web", "level": "info", "status": 200.0}),
+	message(3, map[string]interface{}{"message": "Refused connection from canary", "service": "web", "level": "error", "user": "bob"}),
+	message(4, map[string]interface{}{"message": "Retrying request", "service": "worker", "level": "info"}),
+}
+
+// Creates a client returning the given messages
+type NewClient func(t *testing.T, messages []common.LogMessage) common.Client
+
+func mustParseQueryFilter(s string) common.QueryFilter {
+	filter, err := common.ParseQueryFilter(s)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
+
+func mustParseExpression(s string) common.Expression {
+	expr, err := common.ParseExpression(s)
+	if err != nil {
+		panic(err)
+	}
+	return expr
+}
+
+var cases = []struct {
+	name     string
+	query    common.Query
+	expected []string
+}{
+	{"all", common.Query{}, []string{
+		"Connection to db-1 refused", "Connection to db-2 timed out after 3000 ms", "User alice logged in", "Refused connection from canary", "Retrying request"}},
+	{"word", common.Query{QueryString: "refused"}, []string{"Connection to db-1 refused", "Refused connection from canary"}},
+	{"whole words only", common.Query{QueryString: "retry"}, []string{}},
+	{"case insensitive", common.Query{QueryString: "CONNECTION"}, []string{
+		"Connection to db-1 refused", "Connection to db-2 timed out after 3000 ms", "Refused connection from canary"}},
+	{"multiple terms", common.Query{QueryString: "connection refused"}, []string{"Connection to db-1 refused", "Refused connection from canary"}},
+	{"phrase", common.Query{QueryString: `"refused connection"`}, []string{"Refused connection from canary"}},
+	{"punctuation", common.Query{QueryString: "db-2"}, []string{"Connection to db-2 timed out after 3000 ms"}},
+	{"wildcard", common.Query{QueryString: "retr*"}, []string{"Retrying request"}},
+	{"other attributes", common.Query{QueryString: "alice"}, []string{"User alice logged in"}},
+	{"field", common.Query{QueryString: "service:api"}, []string{"Connection to db-1 refused", "Connection to db-2 timed out after 3000 ms"}},
+	{"field phrase", common.Query{QueryString: `message:"logged in"`}, []string{"User alice logged in"}},
+	{"filter", common.Query{Filters: []common.QueryFilter{mustParseQueryFilter("level=error")}}, []string{
+		"Connection to db-1 refused", "Refused connection from canary"}},
+	{"comparison", common.Query{Filters: []common.QueryFilter{mustParseQueryFilter("status>=500")}}, []string{
+		"Connection to db-1 refused", "Connection to db-2 timed out after 3000 ms"}},
+	{"exists", common.Query{Filters: []common.QueryFilter{mustParseQueryFilter("user?")}}, []string{"Refused connection from canary"}},
+	{"in", common.Query{Filters: []common.QueryFilter{mustParseQueryFilter("service in (web,worker)")}}, []string{
+		"User alice logged in", "Refused connection from canary", "Retrying request"}},
+	{"query and filter", common.Query{QueryString: "connection", Filters: []common.QueryFilter{mustParseQueryFilter("service=web")}}, []string{
+		"Refused connection from canary"}},
+	{"expression", common.Query{Expression: mustParseExpression(`(level=warn OR level=info) AND NOT "logged in"`)}, []string{
+		"Connection to db-2 timed out after 3000 ms", "Retrying request"}},
+}
+
+// Runs all conformance tests against clients created by newClient
+func Run(t *testing.T, newClient NewClient) {
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := newClient(t, Messages)
+			results := make([]string, 0, len(c.expected))
+			for message := range client.Query(c.query) {
+				text, _ := message.Attributes["message"].(string)
+				results = append(results, text)
+			}
+			expected := append([]string{}, c.expected...)
+			sort.Strings(results)
+			sort.Strings(expected)
+			if !reflect.DeepEqual(results, expected) {
+				t.Errorf("Expected %q, got %q", expected, results)
+			}
+		})
+	}
+}
@@ -33,7 +33,11 @@ func (client *DockerClient) Query(query common.Query) <-chan common.LogMessage {
 	resultChan := make(chan common.LogMessage)
 	runningCommands := 0
 	for _, containerName := range GetRunningContainers(client.containerPattern) {
-		command := []string{"docker", "logs", "--tail", fmt.Sprintf("%d", query.MaxResults)}
+		tail := "all"
+		if query.MaxResults > 0 {
+			tail = fmt.Sprintf("%d", query.MaxResults)
+		}
+		command := []string{"docker", "logs", "--tail", tail}
 		if query.Follow {
 			command = append(command, "-f")
 		}
@@ -41,7 +45,7 @@ func (client *DockerClient) Query(query common.Query) <-chan common.LogMessage {
 		client := subprocess.New(command)
 		runningCommands++
 		go func() {
-			for message := range client.Query(query) {
+			for message := range client.Query(query.Unsorted()) {
 				message.Attributes["@container"] = containerName
 				resultChan <- message
 			}
@@ -51,7 +55,7 @@ func (client *DockerClient) Query(query common.Query) <-chan common.LogMessage {
 			}
 		}()
 	}
-	return resultChan
+	return common.SortMessages(resultChan, query)
 }
 
 func New(containerPattern string) *DockerClient {
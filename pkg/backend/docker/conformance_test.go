@@ -0,0 +1,20 @@
+package docker
+
+import (
+	"os"
+	"testing"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/backend/conformance"
+)
+
+// Needs a container that logged conformance.Messages as JSON lines
+func TestConformance(t *testing.T) {
+	pattern := os.Getenv("AX_CONFORMANCE_DOCKER_CONTAINER")
+	if pattern == "" {
+		t.Skip("Set AX_CONFORMANCE_DOCKER_CONTAINER to run against docker")
+	}
+	conformance.Run(t, func(t *testing.T, messages []common.LogMessage) common.Client {
+		return New(pattern)
+	})
+}
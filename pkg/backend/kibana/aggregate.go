@@ -0,0 +1,271 @@
+package kibana
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+// Number of buckets requested per level when not limited
+const maxBuckets = 10000
+
+func aggregationName(level int) string {
+	return fmt.Sprintf("by%d", level)
+}
+
+func missingAggregationName(level int) string {
+	return fmt.Sprintf("missing%d", level)
+}
+
+// Builds nested terms aggregations, one level per field. The missing option of
+// the terms aggregation has to be of the type of the field, so messages
+// without the field are counted by a missing aggregation next to it instead
+// (the total number of hits can't be relied on to work that out, as it's
+// capped by recent Elasticsearch versions).
+func termsAggregation(fields []string, level, top int) JsonObject {
+	terms := JsonObject{
+		"terms": JsonObject{
+			"field": fields[level],
+			"size":  top,
+		},
+	}
+	missing := JsonObject{
+		"missing": JsonObject{
+			"field": fields[level],
+		},
+	}
+	if level+1 < len(fields) {
+		terms["aggs"] = termsAggregation(fields, level+1, top)
+		missing["aggs"] = termsAggregation(fields, level+1, top)
+	}
+	return JsonObject{
+		aggregationName(level):        terms,
+		missingAggregationName(level): missing,
+	}
+}
+
+// Flattens nested terms aggregation buckets into group counts
+func collectBuckets(aggregations JsonObject, level, depth int, prefix []string, into []common.GroupCount) []common.GroupCount {
+	aggregation, _ := aggregations[aggregationName(level)].(map[string]interface{})
+	buckets, _ := aggregation["buckets"].([]interface{})
+	if missing, ok := aggregations[missingAggregationName(level)].(map[string]interface{}); ok {
+		if count, _ := missing["doc_count"].(float64); count > 0 {
+			missing["key"] = common.MissingValue
+			buckets = append(buckets, missing)
+		}
+	}
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key := bucket["key"]
+		if keyString, ok := bucket["key_as_string"]; ok {
+			key = keyString
+		}
+		values := append(append([]string{}, prefix...), fmt.Sprintf("%v", key))
+		if level+1 < depth {
+			into = collectBuckets(JsonObject(bucket), level+1, depth, values, into)
+		} else {
+			count, _ := bucket["doc_count"].(float64)
+			into = append(into, common.GroupCount{Values: values, Count: int(count)})
+		}
+	}
+	return into
+}
+
+// Terms aggregations fail on analyzed text fields, for which Elasticsearch
+// usually indexes a <field>.keyword sub field as well. Returns the fields with
+// the ones that can't be aggregated on replaced by their keyword sub field.
+func (client *Client) keywordFields(fields []string) []string {
+	result := make([]string, 0, len(fields))
+	for _, field := range fields {
+		_, err := client.search(client.Index, JsonObject{
+			"size": 0,
+			"aggs": JsonObject{
+				"probe": JsonObject{"terms": JsonObject{"field": field, "size": 1}},
+			},
+		})
+		if err != nil && !strings.HasSuffix(field, ".keyword") {
+			field += ".keyword"
+		}
+		result = append(result, field)
+	}
+	return result
+}
+
+func totalHits(response *SearchResponse) int {
+	switch total := response.Hits.Total.(type) {
+	case float64:
+		return int(total)
+	case map[string]interface{}:
+		value, _ := total["value"].(float64)
+		return int(value)
+	}
+	return 0
+}
+
+// Counts messages using (nested) terms aggregations, so no hits have to be fetched
+func (client *Client) CountBy(q common.Query, fields []string, top int) ([]common.GroupCount, error) {
+	if err := checkRegexps(q); err != nil {
+		return nil, err
+	}
+	if q.Before == nil {
+		before := time.Now().Add(12 * time.Hour)
+		q.Before = &before // Limit sanity
+	}
+	searchBody := JsonObject{
+		"size":  0,
+		"query": buildQuery(q),
+	}
+	size := top
+	if size <= 0 {
+		size = maxBuckets
+	}
+	if len(fields) > 0 {
+		searchBody["aggs"] = termsAggregation(fields, 0, size)
+	} else {
+		// The total is capped at 10000 by Elasticsearch 7 otherwise
+		searchBody["track_total_hits"] = true
+	}
+	response, err := client.search(client.Index, searchBody)
+	if err != nil && len(fields) > 0 {
+		searchBody["aggs"] = termsAggregation(client.keywordFields(fields), 0, size)
+		response, err = client.search(client.Index, searchBody)
+	} else if err != nil {
+		// Versions before 7 don't know track_total_hits, and always count all hits
+		delete(searchBody, "track_total_hits")
+		response, err = client.search(client.Index, searchBody)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return []common.GroupCount{common.GroupCount{Values: []string{}, Count: totalHits(response)}}, nil
+	}
+	groups := collectBuckets(response.Aggregations, 0, len(fields), []string{}, make([]common.GroupCount, 0, top))
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Count > groups[j].Count
+	})
+	if top > 0 && len(groups) > top {
+		groups = groups[:top]
+	}
+	return groups, nil
+}
+
+// Determines the time range of messages matching a query with min/max aggregations
+func (client *Client) timeRange(q common.Query) (time.Time, time.Time, error) {
+	response, err := client.search(client.Index, JsonObject{
+		"size":  0,
+		"query": buildQuery(q),
+		"aggs": JsonObject{
+			"from": JsonObject{"min": JsonObject{"field": "@timestamp"}},
+			"to":   JsonObject{"max": JsonObject{"field": "@timestamp"}},
+		},
+	})
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	aggregationTime := func(name string) time.Time {
+		aggregation, _ := response.Aggregations[name].(map[string]interface{})
+		millis, _ := aggregation["value"].(float64)
+		return time.Unix(0, int64(millis)*int64(time.Millisecond))
+	}
+	return aggregationTime("from"), aggregationTime("to"), nil
+}
+
+// Converts a histogram interval to whole milliseconds (at least one), raised if
+// needed so that from until to is split into at most common.MaxHistogramBuckets
+// buckets, as Elasticsearch rejects aggregations beyond search.max_buckets
+func histogramMillis(from, to time.Time, interval time.Duration) int64 {
+	millis := int64(interval / time.Millisecond)
+	if millis < 1 {
+		millis = 1
+	}
+	span := int64(to.Sub(from) / time.Millisecond)
+	if min := (span + common.MaxHistogramBuckets - 2) / (common.MaxHistogramBuckets - 1); millis < min {
+		millis = min
+	}
+	return millis
+}
+
+// Buckets messages by time using a date_histogram aggregation. Without an
+// interval, the time range of matching messages is looked up first to pick one.
+func (client *Client) Histogram(q common.Query, interval time.Duration) ([]common.TimeBucket, error) {
+	if err := checkRegexps(q); err != nil {
+		return nil, err
+	}
+	if q.Before == nil {
+		before := time.Now()
+		q.Before = &before
+	}
+	var from, to time.Time
+	if interval == 0 || q.After == nil {
+		var err error
+		from, to, err = client.timeRange(q)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if interval == 0 {
+		interval = common.HistogramInterval(to.Sub(from))
+	}
+	if q.After != nil {
+		from, to = *q.After, *q.Before
+	}
+	histogram := JsonObject{
+		"field":          "@timestamp",
+		"fixed_interval": fmt.Sprintf("%dms", histogramMillis(from, to, interval)),
+		"min_doc_count":  0,
+	}
+	if q.After != nil {
+		// Include empty buckets for the whole range
+		histogram["extended_bounds"] = JsonObject{
+			"min": unixMillis(*q.After),
+			"max": unixMillis(*q.Before),
+		}
+	}
+	searchBody := JsonObject{
+		"size":  0,
+		"query": buildQuery(q),
+		"aggs": JsonObject{
+			"histogram": JsonObject{"date_histogram": histogram},
+		},
+	}
+	response, err := client.search(client.Index, searchBody)
+	if err != nil {
+		// fixed_interval was added in Elasticsearch 7.2, older versions only have
+		// interval (which is deprecated since)
+		histogram["interval"] = histogram["fixed_interval"]
+		delete(histogram, "fixed_interval")
+		response, err = client.search(client.Index, searchBody)
+	}
+	if err != nil {
+		return nil, err
+	}
+	aggregation, _ := response.Aggregations["histogram"].(map[string]interface{})
+	rawBuckets, _ := aggregation["buckets"].([]interface{})
+	buckets := make([]common.TimeBucket, 0, len(rawBuckets))
+	for _, b := range rawBuckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		millis, _ := bucket["key"].(float64)
+		count, _ := bucket["doc_count"].(float64)
+		buckets = append(buckets, common.TimeBucket{
+			Start: time.Unix(0, int64(millis)*int64(time.Millisecond)),
+			Count: int(count),
+		})
+	}
+	return buckets, nil
+}
+
+var (
+	_ common.GroupCounter  = &Client{}
+	_ common.Histogrammer  = &Client{}
+	_ common.ResultLimiter = &Client{}
+)
@@ -0,0 +1,301 @@
+package kibana
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+type fakeDocument struct {
+	id string
+	ts time.Time
+}
+
+// Emulates just enough of Elasticsearch's _msearch to test paging: sorting on
+// @timestamp and _id, size, search_after and _source
+func fakeElasticsearch(t *testing.T, docs []fakeDocument) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scanner := bufio.NewScanner(r.Body)
+		result := QueryResult{Responses: make([]SearchResponse, 0, 1)}
+		for scanner.Scan() { // Header line
+			scanner.Scan()
+			var body struct {
+				Size        int          `json:"size"`
+				Sort        []JsonObject `json:"sort"`
+				SearchAfter JsonList     `json:"search_after"`
+				Source      *bool        `json:"_source"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			var response SearchResponse
+			if body.Size > 10000 {
+				response.Error = JsonObject{"type": "query_phase_execution_exception"}
+			} else {
+				response.Hits.Hits = fakeSearch(docs, body.Size, body.Sort, body.SearchAfter, body.Source == nil || *body.Source)
+			}
+			result.Responses = append(result.Responses, response)
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+}
+
+func fakeSearch(docs []fakeDocument, size int, sortOrder []JsonObject, searchAfter JsonList, withSource bool) []Hit {
+	desc := sortOrder[0]["@timestamp"].(map[string]interface{})["order"] == "desc"
+	sorted := make([]fakeDocument, len(docs))
+	copy(sorted, docs)
+	less := func(a, b fakeDocument) bool {
+		if a.ts.Equal(b.ts) {
+			return a.id < b.id
+		}
+		return a.ts.Before(b.ts)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if desc {
+			return less(sorted[j], sorted[i])
+		}
+		return less(sorted[i], sorted[j])
+	})
+	hits := make([]Hit, 0, size)
+	for _, doc := range sorted {
+		if searchAfter != nil {
+			cursor := fakeDocument{
+				ts: time.Unix(0, int64(searchAfter[0].(float64))*int64(time.Millisecond)),
+				id: searchAfter[1].(string),
+			}
+			if (desc && !less(doc, cursor)) || (!desc && !less(cursor, doc)) {
+				continue
+			}
+		}
+		if len(hits) >= size {
+			break
+		}
+		hit := Hit{
+			ID:   doc.id,
+			Sort: JsonList{doc.ts.UnixNano() / int64(time.Millisecond), doc.id},
+		}
+		if withSource {
+			hit.Source = JsonObject{"@timestamp": doc.ts.Format(time.RFC3339), "message": doc.id}
+		}
+		hits = append(hits, hit)
+	}
+	return hits
+}
+
+func TestQueryPaging(t *testing.T) {
+	docs := make([]fakeDocument, 0, 2500)
+	start := time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 2500; i++ {
+		// Two documents per second to make sure ties on @timestamp are handled
+		docs = append(docs, fakeDocument{
+			id: fmt.Sprintf("doc%05d", i),
+			ts: start.Add(time.Duration(i/2) * time.Second),
+		})
+	}
+	server := fakeElasticsearch(t, docs)
+	defer server.Close()
+	client := New(server.URL, "", "logs")
+
+	for _, maxResults := range []int{10, 1000, 1001, 2499, 2500, 20000, 0} {
+		expectedCount := maxResults
+		if maxResults == 0 || maxResults > len(docs) {
+			expectedCount = len(docs)
+		}
+		messages := make([]common.LogMessage, 0, expectedCount)
+		for message := range client.Query(common.Query{MaxResults: maxResults}) {
+			messages = append(messages, message)
+		}
+		if len(messages) != expectedCount {
+			t.Errorf("Expected %d messages with -n %d, got %d", expectedCount, maxResults, len(messages))
+			continue
+		}
+		for i, message := range messages {
+			expectedID := docs[len(docs)-expectedCount+i].id
+			if message.ID != expectedID {
+				t.Errorf("Expected %s at position %d with -n %d, got %s", expectedID, i, maxResults, message.ID)
+				break
+			}
+		}
+	}
+}
+
+func TestQuerySortDesc(t *testing.T) {
+	docs := make([]fakeDocument, 0, 1500)
+	start := time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 1500; i++ {
+		docs = append(docs, fakeDocument{
+			id: fmt.Sprintf("doc%05d", i),
+			ts: start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	server := fakeElasticsearch(t, docs)
+	defer server.Close()
+	client := New(server.URL, "", "logs")
+
+	counter := 0
+	for message := range client.Query(common.Query{MaxResults: 1200, SortOrder: "desc"}) {
+		if expectedID := docs[len(docs)-1-counter].id; message.ID != expectedID {
+			t.Fatalf("Expected %s at position %d, got %s", expectedID, counter, message.ID)
+		}
+		counter++
+	}
+	if counter != 1200 {
+		t.Errorf("Expected 1200 messages, got %d", counter)
+	}
+}
+
+func TestCaseSensitivePaging(t *testing.T) {
+	docs := make([]fakeDocument, 0, 30)
+	start := time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		docs = append(docs, fakeDocument{
+			id: fmt.Sprintf("doc%02d", i),
+			ts: start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	server := fakeElasticsearch(t, docs)
+	defer server.Close()
+	client := New(server.URL, "", "logs")
+
+	// The fake ignores the query, so the most recent hits don't match locally
+	ids := make([]string, 0, 3)
+	for message := range client.Query(common.Query{QueryString: "doc1*", CaseSensitive: true, MaxResults: 3}) {
+		ids = append(ids, message.ID)
+	}
+	if expected := []string{"doc17", "doc18", "doc19"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected the 3 most recent matches, got %v", ids)
+	}
+}
+
+func TestContext(t *testing.T) {
+	docs := make([]fakeDocument, 0, 10)
+	start := time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		docs = append(docs, fakeDocument{
+			id: fmt.Sprintf("doc%d", i),
+			ts: start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	server := fakeElasticsearch(t, docs)
+	defer server.Close()
+	client := New(server.URL, "", "logs")
+
+	tests := []struct {
+		matches         []int
+		sortField       string
+		sortOrder       string
+		expected        []string
+		expectedContext []bool
+	}{
+		{[]int{3, 7}, "", "", []string{"doc2", "doc3", "doc4", "doc5", "doc6", "doc7", "doc8", "doc9"}, []bool{true, false, true, true, true, false, true, true}},
+		{[]int{3, 5}, "", "", []string{"doc2", "doc3", "doc4", "doc5", "doc6", "doc7", "doc8"}, []bool{true, false, true, false, true, true, true}},
+		// Matches already shown as context are shown again as matches when not sorted by time
+		{[]int{3, 4}, "level", "", []string{"doc2", "doc3", "doc4", "doc5", "doc6", "doc4", "doc7"}, []bool{true, false, true, true, true, false, true}},
+		{[]int{7, 3}, "", "desc", []string{"doc9", "doc8", "doc7", "doc6", "doc5", "doc4", "doc3", "doc2"}, []bool{true, true, false, true, true, true, false, true}},
+	}
+	for _, test := range tests {
+		matches := make(chan common.LogMessage)
+		go func() {
+			for _, i := range test.matches {
+				matches <- common.LogMessage{ID: docs[i].id, Timestamp: docs[i].ts, Attributes: map[string]interface{}{"host": "a"}}
+			}
+			close(matches)
+		}()
+		ids := make([]string, 0, len(test.expected))
+		isContext := make([]bool, 0, len(test.expected))
+		q := common.Query{ContextBefore: 1, ContextAfter: 3, ContextField: "host", SortField: test.sortField, SortOrder: test.sortOrder}
+		for message := range client.withContext(q, matches) {
+			ids = append(ids, message.ID)
+			isContext = append(isContext, message.IsContext)
+		}
+		if !reflect.DeepEqual(ids, test.expected) || !reflect.DeepEqual(isContext, test.expectedContext) {
+			t.Errorf("Wrong context for %v (%s %s): %v %v", test.matches, test.sortField, test.sortOrder, ids, isContext)
+		}
+	}
+}
+
+func TestCountTotal(t *testing.T) {
+	bodies := make([]JsonObject, 0, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Scan() // Header line
+		scanner.Scan()
+		var body JsonObject
+		if err := json.Unmarshal(scanner.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, body)
+		if _, ok := body["track_total_hits"]; ok && len(bodies) == 1 {
+			// Like Elasticsearch 6
+			w.Write([]byte(`{"responses":[{"error":{"type":"parsing_exception"}}]}`))
+			return
+		}
+		w.Write([]byte(`{"responses":[{"hits":{"total":12345,"hits":[]}}]}`))
+	}))
+	defer server.Close()
+	client := New(server.URL, "", "logs")
+
+	groups, err := client.CountBy(common.Query{}, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Count != 12345 {
+		t.Errorf("Wrong count: %+v", groups)
+	}
+	if len(bodies) != 2 || bodies[0]["track_total_hits"] != true {
+		t.Errorf("Expected all hits to be tracked first: %v", bodies)
+	}
+	if _, ok := bodies[1]["track_total_hits"]; ok {
+		t.Errorf("Expected a retry without track_total_hits: %v", bodies[1])
+	}
+}
+
+func TestHistogramInterval(t *testing.T) {
+	var intervals []interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Scan() // Header line
+		scanner.Scan()
+		var body JsonObject
+		if err := json.Unmarshal(scanner.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		aggs, _ := body["aggs"].(map[string]interface{})
+		histogram, _ := aggs["histogram"].(map[string]interface{})
+		dateHistogram, _ := histogram["date_histogram"].(map[string]interface{})
+		intervals = append(intervals, dateHistogram["fixed_interval"])
+		w.Write([]byte(`{"responses":[{"hits":{"total":0,"hits":[]},"aggregations":{"histogram":{"buckets":[]}}}]}`))
+	}))
+	defer server.Close()
+	client := New(server.URL, "", "logs")
+
+	before := time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		after    time.Time
+		interval time.Duration
+		expected string
+	}{
+		{before.Add(-time.Minute), 500 * time.Millisecond, "500ms"},
+		{before.Add(-time.Minute), time.Microsecond, "7ms"},
+		{before.Add(-time.Hour), time.Second, "1000ms"},
+		{before.Add(-24 * time.Hour), time.Second, "8641ms"},
+	}
+	for _, test := range tests {
+		intervals = nil
+		after := test.after
+		if _, err := client.Histogram(common.Query{After: &after, Before: &before}, test.interval); err != nil {
+			t.Fatal(err)
+		}
+		if len(intervals) != 1 || intervals[0] != test.expected {
+			t.Errorf("Interval %s from %s: expected %s, got %v", test.interval, test.after, test.expected, intervals)
+		}
+	}
+}
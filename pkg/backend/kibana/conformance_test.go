@@ -0,0 +1,22 @@
+package kibana
+
+import (
+	"os"
+	"testing"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/backend/conformance"
+)
+
+// Needs a Kibana instance with conformance.Messages indexed, e.g.
+// AX_CONFORMANCE_KIBANA_URL=http://localhost:5601 AX_CONFORMANCE_KIBANA_INDEX=ax-conformance
+func TestConformance(t *testing.T) {
+	url := os.Getenv("AX_CONFORMANCE_KIBANA_URL")
+	index := os.Getenv("AX_CONFORMANCE_KIBANA_INDEX")
+	if url == "" || index == "" {
+		t.Skip("Set AX_CONFORMANCE_KIBANA_URL and AX_CONFORMANCE_KIBANA_INDEX to run against Kibana")
+	}
+	conformance.Run(t, func(t *testing.T, messages []common.LogMessage) common.Client {
+		return New(url, os.Getenv("AX_CONFORMANCE_KIBANA_AUTH"), index)
+	})
+}
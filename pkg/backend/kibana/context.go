@@ -0,0 +1,214 @@
+package kibana
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+// How far from a match to look for context messages
+const contextWindow = time.Hour
+
+// In follow mode, how long to wait for the next match before looking up context after the last one
+const contextFollowDelay = 10 * time.Second
+
+// Cursor to a message's position in the @timestamp, _id sort order used by queryMessages
+func messageCursor(message common.LogMessage) JsonList {
+	return JsonList{message.Timestamp.UnixNano() / int64(time.Millisecond), message.ID}
+}
+
+func positionBefore(a, b common.LogMessage) bool {
+	if a.Timestamp.Equal(b.Timestamp) {
+		return a.ID < b.ID
+	}
+	return a.Timestamp.Before(b.Timestamp)
+}
+
+// Outside of follow mode, the number of matches context is looked up for, as
+// every match takes a request
+const maxContextMatches = 200
+
+// Lookup of up to size messages directly before (order "desc") or after (order
+// "asc") a match
+type contextLookup struct {
+	match common.LogMessage
+	order string
+	size  int
+}
+
+// Fetches context for several matches with a single request. Context messages
+// share the value of q.ContextField with their match, no context is looked up
+// for matches without it. Returns the messages of every lookup oldest first.
+func (client *Client) fetchContext(q common.Query, lookups []contextLookup) [][]common.LogMessage {
+	results := make([][]common.LogMessage, len(lookups))
+	searchBodies := make([]JsonObject, 0, len(lookups))
+	queried := make([]int, 0, len(lookups))
+	for i, lookup := range lookups {
+		value, ok := lookup.match.Attributes[q.ContextField]
+		if !ok {
+			continue
+		}
+		after := lookup.match.Timestamp.Add(-contextWindow)
+		before := lookup.match.Timestamp.Add(contextWindow)
+		contextQuery := common.Query{
+			After:  &after,
+			Before: &before,
+			Filters: []common.QueryFilter{
+				common.QueryFilter{FieldName: q.ContextField, Operator: "=", Value: fmt.Sprintf("%v", value)},
+			},
+		}
+		searchBodies = append(searchBodies, messagesSearchBody(contextQuery, searchPage{
+			order:       lookup.order,
+			size:        lookup.size,
+			searchAfter: messageCursor(lookup.match),
+		}))
+		queried = append(queried, i)
+	}
+	if len(searchBodies) == 0 {
+		return results
+	}
+	responses, err := client.multiSearch(client.Index, searchBodies)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not fetch context: %v\n", err)
+		return results
+	}
+	for j, response := range responses {
+		i := queried[j]
+		hits := response.Hits.Hits
+		messages := make([]common.LogMessage, len(hits))
+		for k, hit := range hits {
+			message, err := hitToMessage(hit, common.Query{})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not fetch context: %v\n", err)
+				messages = nil
+				break
+			}
+			message.IsContext = true
+			if lookups[i].order == "desc" {
+				messages[len(hits)-1-k] = message
+			} else {
+				messages[k] = message
+			}
+		}
+		results[i] = messages
+	}
+	return results
+}
+
+// Adds context messages around every match by querying around each of them.
+// Context shown before a match in the output is looked up along with it, context
+// shown after it once the next match is known, so that with results sorted on
+// @timestamp it can stop right before it, like grep does. Both lookups are done
+// with a single request.
+func (client *Client) withContext(q common.Query, matches <-chan common.LogMessage) <-chan common.LogMessage {
+	resultChan := make(chan common.LogMessage)
+	chronological := q.SortField == "" || q.SortField == "@timestamp"
+	desc := q.SortOrder == "desc"
+	leading := contextLookup{order: "desc", size: q.ContextBefore}
+	trailing := contextLookup{order: "asc", size: q.ContextAfter}
+	if desc {
+		leading = contextLookup{order: "asc", size: q.ContextAfter}
+		trailing = contextLookup{order: "desc", size: q.ContextBefore}
+	}
+	// Whether a comes before b in the output
+	outputBefore := func(a, b common.LogMessage) bool {
+		if desc {
+			return positionBefore(b, a)
+		}
+		return positionBefore(a, b)
+	}
+	inOutputOrder := func(messages []common.LogMessage) []common.LogMessage {
+		if !desc {
+			return messages
+		}
+		reversed := make([]common.LogMessage, len(messages))
+		for i, message := range messages {
+			reversed[len(messages)-1-i] = message
+		}
+		return reversed
+	}
+	go func() {
+		// Whether messages were shown as a match, rather than just as context
+		seenAsMatch := make(map[string]bool)
+		// The last match, and whether its trailing context is still to be shown
+		var last *common.LogMessage
+		trailingPending := false
+		matchCount := 0
+		emit := func(message common.LogMessage) {
+			// A match shown as context of another match before is still shown as a match
+			if asMatch, seen := seenAsMatch[message.ID]; seen && (asMatch || message.IsContext) {
+				return
+			}
+			seenAsMatch[message.ID] = !message.IsContext
+			message.Attributes = q.Project(message.Attributes)
+			resultChan <- message
+		}
+		trailingLookups := func() []contextLookup {
+			if !trailingPending || trailing.size == 0 {
+				return nil
+			}
+			trailingPending = false
+			lookup := trailing
+			lookup.match = *last
+			return []contextLookup{lookup}
+		}
+		emitTrailing := func(messages []common.LogMessage, next *common.LogMessage) {
+			for _, message := range inOutputOrder(messages) {
+				if next != nil && chronological && !outputBefore(message, *next) {
+					break
+				}
+				emit(message)
+			}
+		}
+		for {
+			var timeout <-chan time.Time
+			if q.Follow && trailingPending {
+				timeout = time.After(contextFollowDelay)
+			}
+			select {
+			case match, ok := <-matches:
+				if !ok {
+					for _, messages := range client.fetchContext(q, trailingLookups()) {
+						emitTrailing(messages, nil)
+					}
+					close(resultChan)
+					return
+				}
+				lookups := trailingLookups()
+				trailingCount := len(lookups)
+				matchCount++
+				withContext := q.Follow || matchCount <= maxContextMatches
+				if withContext && leading.size > 0 {
+					lookup := leading
+					lookup.match = match
+					lookups = append(lookups, lookup)
+				}
+				if matchCount == maxContextMatches+1 && !q.Follow {
+					fmt.Fprintf(os.Stderr, "Only showing context for the first %d matches\n", maxContextMatches)
+				}
+				contexts := client.fetchContext(q, lookups)
+				for _, messages := range contexts[:trailingCount] {
+					emitTrailing(messages, &match)
+				}
+				for _, messages := range contexts[trailingCount:] {
+					for _, message := range inOutputOrder(messages) {
+						// Don't go back past the previous match
+						if last == nil || !chronological || outputBefore(*last, message) {
+							emit(message)
+						}
+					}
+				}
+				emit(match)
+				last = &match
+				trailingPending = withContext
+			case <-timeout:
+				for _, messages := range client.fetchContext(q, trailingLookups()) {
+					emitTrailing(messages, nil)
+				}
+			}
+		}
+	}()
+	return resultChan
+}
@@ -2,9 +2,11 @@ package kibana
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/egnyte/ax/pkg/backend/common"
 
@@ -17,38 +19,52 @@ type JsonObject map[string]interface{}
 type JsonList []interface{}
 
 type QueryResult struct {
-	Responses []struct {
-		Hits struct {
-			Hits []Hit `json:"hits"`
-		} `json:"hits"`
-	} `json:"responses"`
+	Responses []SearchResponse `json:"responses"`
+}
+
+type SearchResponse struct {
+	Hits struct {
+		// A number before Elasticsearch 7, an object with a value after
+		Total interface{} `json:"total"`
+		Hits  []Hit       `json:"hits"`
+	} `json:"hits"`
+	Aggregations JsonObject  `json:"aggregations"`
+	Error        interface{} `json:"error"`
 }
 
 type Hit struct {
 	ID     string     `json:"_id"`
 	Source JsonObject `json:"_source"`
+	// Sort values of this hit, used as cursor to request the next page
+	Sort JsonList `json:"sort"`
 }
 
-type hitsByAscDate []Hit
+// Number of hits requested at once when paging through results
+const pageSize = 1000
 
-func (a hitsByAscDate) Len() int      { return len(a) }
-func (a hitsByAscDate) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a hitsByAscDate) Less(i, j int) bool {
-	return a[i].Source["@timestamp"].(string) < a[j].Source["@timestamp"].(string)
+// Describes which page of results to fetch, sorted on a field (@timestamp if
+// empty) and then _id so that every hit has a unique position to continue from
+type searchPage struct {
+	field       string
+	order       string
+	size        int
+	searchAfter JsonList
+	noSource    bool
 }
 
-func (client *Client) queryMessages(subIndex string, query common.Query) ([]Hit, error) {
-	queryString := fmt.Sprintf("\"%s\"", query.QueryString) // TODO: Handle quotes properly
-	if query.QueryString == "" {
-		queryString = "*"
-	}
-	mustFilters := JsonList{
-		JsonObject{
+func buildQuery(query common.Query) JsonObject {
+	mustFilters := JsonList{}
+	if query.RawQueryString && query.QueryString != "" {
+		mustFilters = append(mustFilters, JsonObject{
 			"query_string": JsonObject{
 				"analyze_wildcard": true,
-				"query":            queryString,
+				"query":            query.QueryString,
 			},
-		},
+		})
+	} else {
+		for _, term := range common.ParseQueryString(query.QueryString) {
+			mustFilters = append(mustFilters, termQuery(term))
+		}
 	}
 
 	if query.After != nil || query.Before != nil {
@@ -68,49 +84,96 @@ func (client *Client) queryMessages(subIndex string, query common.Query) ([]Hit,
 		mustFilters = append(mustFilters, rangeObj)
 	}
 	mustNotFilters := JsonList{}
+	if !query.CaseSensitive {
+		// Elasticsearch would exclude other cases too, so these are only checked locally
+		for _, term := range query.ExcludedTerms() {
+			mustNotFilters = append(mustNotFilters, termQuery(term))
+		}
+	}
 	for _, filter := range query.Filters {
-		m := JsonObject{}
-		switch filter.Operator {
-		case "=":
-			m[filter.FieldName] = JsonObject{
-				"query": filter.Value,
-				"type":  "phrase",
-			}
-			mustFilters = append(mustFilters, JsonObject{
-				"match": m,
-			})
-		case "!=":
-			m[filter.FieldName] = JsonObject{
-				"query": filter.Value,
-				"type":  "phrase",
-			}
-			mustNotFilters = append(mustNotFilters, JsonObject{
-				"match": m,
-			})
+		clause, negate := filterQuery(filter)
+		if negate {
+			mustNotFilters = append(mustNotFilters, clause)
+		} else {
+			mustFilters = append(mustFilters, clause)
 		}
 	}
-	body, err := createMultiSearch(
-		JsonObject{
-			"index":              JsonList{subIndex},
-			"ignore_unavailable": true,
+	if query.Expression != nil {
+		mustFilters = append(mustFilters, expressionClause(query.Expression, query.CaseSensitive, false))
+	}
+	return JsonObject{
+		"bool": JsonObject{
+			"must":     mustFilters,
+			"must_not": mustNotFilters,
 		},
-		JsonObject{
-			"size": query.MaxResults,
-			"sort": JsonList{
-				JsonObject{
-					"@timestamp": JsonObject{
-						"order":         "desc",
-						"unmapped_type": "boolean",
-					},
-				},
+	}
+}
+
+// Builds the body of a search for a page of messages
+func messagesSearchBody(query common.Query, page searchPage) JsonObject {
+	fieldSort := JsonObject{
+		"@timestamp": JsonObject{
+			"order":         page.order,
+			"unmapped_type": "boolean",
+		},
+	}
+	if page.field != "" && page.field != "@timestamp" {
+		fieldSort = JsonObject{
+			page.field: JsonObject{
+				"order":   page.order,
+				"missing": "_last",
 			},
-			"query": JsonObject{
-				"bool": JsonObject{
-					"must":     mustFilters,
-					"must_not": mustNotFilters,
+		}
+	}
+	searchBody := JsonObject{
+		"size": page.size,
+		"sort": JsonList{
+			fieldSort,
+			JsonObject{
+				"_id": JsonObject{
+					"order": page.order,
 				},
 			},
-		})
+		},
+		"query": buildQuery(query),
+	}
+	if page.searchAfter != nil {
+		searchBody["search_after"] = page.searchAfter
+	}
+	if page.noSource {
+		searchBody["_source"] = false
+	}
+	return searchBody
+}
+
+func (client *Client) queryMessages(subIndex string, query common.Query, page searchPage) ([]Hit, error) {
+	response, err := client.search(subIndex, messagesSearchBody(query, page))
+	if err != nil {
+		return nil, err
+	}
+	return response.Hits.Hits, nil
+}
+
+// Performs a single search through Kibana's _msearch proxy
+func (client *Client) search(subIndex string, searchBody JsonObject) (*SearchResponse, error) {
+	responses, err := client.multiSearch(subIndex, []JsonObject{searchBody})
+	if err != nil {
+		return nil, err
+	}
+	return &responses[0], nil
+}
+
+// Performs several searches with a single request through Kibana's _msearch
+// proxy, returning a response per search
+func (client *Client) multiSearch(subIndex string, searchBodies []JsonObject) ([]SearchResponse, error) {
+	objs := make([]interface{}, 0, 2*len(searchBodies))
+	for _, searchBody := range searchBodies {
+		objs = append(objs, JsonObject{
+			"index":              JsonList{subIndex},
+			"ignore_unavailable": true,
+		}, searchBody)
+	}
+	body, err := createMultiSearch(objs...)
 	if err != nil {
 		return nil, err
 	}
@@ -131,9 +194,208 @@ func (client *Client) queryMessages(subIndex string, query common.Query) ([]Hit,
 	if err != nil {
 		return nil, err
 	}
-	hits := data.Responses[0].Hits.Hits
-	sort.Sort(hitsByAscDate(hits))
-	return hits, nil
+	if len(data.Responses) == 0 {
+		return nil, errors.New("Empty response from Kibana")
+	}
+	if len(data.Responses) != len(searchBodies) {
+		return nil, fmt.Errorf("Expected %d responses from Kibana, got %d", len(searchBodies), len(data.Responses))
+	}
+	for _, response := range data.Responses {
+		if response.Error != nil {
+			return nil, fmt.Errorf("Query failed: %s", common.MustJsonEncode(response.Error))
+		}
+	}
+	return data.Responses, nil
+}
+
+var rangeOperators = map[string]string{
+	"<":  "lt",
+	"<=": "lte",
+	">":  "gt",
+	">=": "gte",
+}
+
+func matchQuery(fieldName, value string) JsonObject {
+	return JsonObject{
+		"match": JsonObject{
+			fieldName: JsonObject{
+				"query": value,
+				"type":  "phrase",
+			},
+		},
+	}
+}
+
+func matchQueries(fieldName string, values []string) JsonList {
+	queries := make(JsonList, 0, len(values))
+	for _, value := range values {
+		queries = append(queries, matchQuery(fieldName, value))
+	}
+	return queries
+}
+
+// Translates a filter into an Elasticsearch query clause, negate signals
+// that the clause should be added to the must_not list
+func filterQuery(filter common.QueryFilter) (clause JsonObject, negate bool) {
+	switch filter.Operator {
+	case "=", "!=":
+		return matchQuery(filter.FieldName, filter.Value), filter.Operator == "!="
+	case "<", "<=", ">", ">=":
+		return JsonObject{
+			"range": JsonObject{
+				filter.FieldName: JsonObject{
+					rangeOperators[filter.Operator]: rangeValue(filter.Value),
+				},
+			},
+		}, false
+	case "exists", "missing":
+		return JsonObject{
+			"exists": JsonObject{
+				"field": filter.FieldName,
+			},
+		}, filter.Operator == "missing"
+	case "in", "notin":
+		return JsonObject{
+			"bool": JsonObject{
+				"should":               matchQueries(filter.FieldName, filter.Values),
+				"minimum_should_match": 1,
+			},
+		}, filter.Operator == "notin"
+	case "=~", "!~":
+		// Queries are checked with checkRegexps before they are built
+		value, _ := luceneRegexp(filter.Value)
+		return JsonObject{
+			"regexp": JsonObject{
+				filter.FieldName: JsonObject{
+					"value": value,
+				},
+			},
+		}, filter.Operator == "!~"
+	default:
+		panic("Not supported operator")
+	}
+}
+
+// Numbers are sent as numbers so Elasticsearch doesn't compare them as strings,
+// anything else (e.g. dates) is sent as is
+func rangeValue(s string) interface{} {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// Characters with a special meaning in Lucene's query syntax, && and || are
+// covered by escaping & and |. < and > can't be escaped, so they are dropped
+// (they separate words anyway).
+const luceneReservedCharacters = `+-=&|!(){}[]^"~*?:\/`
+
+// Escapes a string so it is taken literally in a query_string query, keeping
+// the wildcards * and ? if allowed
+func escapeLucene(s string, wildcards bool) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '<' || r == '>':
+			sb.WriteRune(' ')
+			continue
+		case wildcards && (r == '*' || r == '?'):
+		case strings.ContainsRune(luceneReservedCharacters, r):
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Quotes a phrase for a query_string query, within quotes only quotes and
+// backslashes have to be escaped
+func quoteLucene(phrase string) string {
+	return fmt.Sprintf(`"%s"`, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(phrase))
+}
+
+// Translates a query string term (see common.QueryTerm) into a query_string query
+func termQuery(term common.QueryTerm) JsonObject {
+	queryString := JsonObject{}
+	if term.Wildcard {
+		// Words of wildcard terms like db-* can't be matched as a phrase, so all
+		// of them have to match instead
+		patterns := make([]string, 0, len(term.Words))
+		for _, word := range term.Words {
+			patterns = append(patterns, escapeLucene(word, true))
+		}
+		queryString["query"] = strings.Join(patterns, " ")
+		if len(patterns) > 1 {
+			queryString["default_operator"] = "AND"
+		}
+		queryString["analyze_wildcard"] = true
+	} else {
+		queryString["query"] = quoteLucene(strings.Join(term.Words, " "))
+	}
+	if term.Field != "" {
+		queryString["fields"] = JsonList{term.Field}
+	}
+	return JsonObject{
+		"query_string": queryString,
+	}
+}
+
+// Compiles a boolean expression into nested bool queries
+func expressionQuery(expr common.Expression) JsonObject {
+	return expressionClause(expr, false, false)
+}
+
+// Translates an expression into a query clause. Case sensitive phrases are
+// checked locally, so the clause only has to match a superset of the messages
+// then: phrases match case insensitively, and phrases that are negated (an odd
+// number of times) match nothing, as that excludes the fewest messages.
+func expressionClause(expr common.Expression, caseSensitive, negated bool) JsonObject {
+	switch e := expr.(type) {
+	case common.AndExpression:
+		return JsonObject{
+			"bool": JsonObject{
+				"must": expressionClauses(e.Operands, caseSensitive, negated),
+			},
+		}
+	case common.OrExpression:
+		return JsonObject{
+			"bool": JsonObject{
+				"should":               expressionClauses(e.Operands, caseSensitive, negated),
+				"minimum_should_match": 1,
+			},
+		}
+	case common.NotExpression:
+		return JsonObject{
+			"bool": JsonObject{
+				"must_not": JsonList{expressionClause(e.Operand, caseSensitive, !negated)},
+			},
+		}
+	case common.FilterExpression:
+		clause, negate := filterQuery(e.Filter)
+		if negate {
+			return JsonObject{
+				"bool": JsonObject{
+					"must_not": JsonList{clause},
+				},
+			}
+		}
+		return clause
+	case common.PhraseExpression:
+		if caseSensitive && negated {
+			return JsonObject{"match_none": JsonObject{}}
+		}
+		return termQuery(common.PhraseTerm(e.Phrase, false))
+	default:
+		panic("Not supported expression")
+	}
+}
+
+func expressionClauses(exprs []common.Expression, caseSensitive, negated bool) JsonList {
+	queries := make(JsonList, 0, len(exprs))
+	for _, expr := range exprs {
+		queries = append(queries, expressionClause(expr, caseSensitive, negated))
+	}
+	return queries
 }
 
 // Implements "follow" mode for Kibana.
@@ -143,6 +405,9 @@ func (client *Client) queryMessages(subIndex string, query common.Query) ([]Hit,
 // resulted in skipping logs.
 func (client *Client) queryFollow(q common.Query) <-chan common.LogMessage {
 	resultChan := make(chan common.LogMessage)
+	if q.MaxResults <= 0 || q.MaxResults > pageSize {
+		q.MaxResults = pageSize
+	}
 	go func() {
 		retries := 0
 		seenMessageIds := make(map[string]bool)
@@ -175,7 +440,74 @@ func (client *Client) queryFollow(q common.Query) <-chan common.LogMessage {
 	return resultChan
 }
 
+// Kibana returns the q.MaxResults most recent (or first sorted) matches
+func (client *Client) LimitsResults() bool {
+	return true
+}
+
 func (client *Client) Query(q common.Query) <-chan common.LogMessage {
+	if err := checkRegexps(q); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if q.ContextBefore > 0 || q.ContextAfter > 0 {
+		if q.ContextField == "" {
+			// Otherwise context would come from whatever else was logged at the time
+			fmt.Fprintln(os.Stderr, "Context with Kibana needs an attribute context messages share with the match, such as host or pod: use --context-by or set context_field on the environment")
+			os.Exit(1)
+		}
+		// Projection happens after looking up context, which may need other attributes
+		matchQuery := q
+		matchQuery.SelectFields, matchQuery.ExcludeFields, matchQuery.RenameFields = nil, nil, nil
+		return client.withContext(q, client.matches(matchQuery))
+	}
+	return client.matches(q)
+}
+
+// Elasticsearch lower cases text when indexing, so case sensitive searches are
+// refined locally. Hits failing the local check would count towards
+// q.MaxResults, so all hits are requested then and read until q.MaxResults
+// of them matched.
+func (client *Client) matches(q common.Query) <-chan common.LogMessage {
+	if !q.CaseSensitive {
+		return client.query(q)
+	}
+	textQuery := q
+	if q.RawQueryString {
+		// Lucene syntax can't be checked locally
+		textQuery.QueryString = ""
+	}
+	// Text may be found in attributes that aren't selected, so project afterwards
+	unprojected := q
+	unprojected.SelectFields, unprojected.ExcludeFields, unprojected.RenameFields = nil, nil, nil
+	limit := 0
+	reverse := false
+	if q.MaxResults > 0 && !q.Follow {
+		unprojected.MaxResults = 0
+		limit = q.MaxResults
+		if (q.SortField == "" || q.SortField == "@timestamp") && q.SortOrder != "desc" {
+			// The most recent matches are wanted, but returned oldest first
+			unprojected.SortOrder = "desc"
+			reverse = true
+		}
+	}
+	matcher := textQuery.Matcher()
+	matchesLocally := func(message common.LogMessage) bool {
+		// Phrases of the expression have to match case sensitively as well
+		return matcher.MatchesText(message) && (q.Expression == nil || q.Expression.Matches(message, true))
+	}
+	resultChan := make(chan common.LogMessage)
+	go func() {
+		for message := range common.FirstMatches(client.query(unprojected), matchesLocally, limit, reverse) {
+			message.Attributes = q.Project(message.Attributes)
+			resultChan <- message
+		}
+		close(resultChan)
+	}()
+	return resultChan
+}
+
+func (client *Client) query(q common.Query) <-chan common.LogMessage {
 	resultChan := make(chan common.LogMessage)
 	if q.Before == nil {
 		before := time.Now().Add(12 * time.Hour)
@@ -185,47 +517,142 @@ func (client *Client) Query(q common.Query) <-chan common.LogMessage {
 		return client.queryFollow(q)
 	}
 	go func() {
-		printedResultsCount := 0
 		fmt.Fprintf(os.Stderr, "Querying index %s\n", client.Index)
-		allMessages, err := client.querySubIndex(client.Index, q)
+		var err error
+		order := "asc"
+		if q.SortOrder == "desc" {
+			order = "desc"
+		}
+		switch {
+		case q.SortField != "" && q.SortField != "@timestamp", order == "desc":
+			// The first q.MaxResults results in the requested order
+			err = client.queryPaged(q, q.SortField, order, nil, resultChan)
+		case q.MaxResults > 0 && q.MaxResults <= pageSize:
+			err = client.queryLatest(q, resultChan)
+		default:
+			// The q.MaxResults most recent results, oldest first
+			var cursor JsonList
+			if q.MaxResults > 0 {
+				cursor, err = client.findCursorBefore(q, q.MaxResults)
+			}
+			if err == nil {
+				err = client.queryPaged(q, "@timestamp", "asc", cursor, resultChan)
+			}
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Could not connect to Kibana: %v", err)
 			os.Exit(2)
 		}
-		for _, message := range allMessages {
-			resultChan <- message
-			printedResultsCount++
-			if printedResultsCount >= q.MaxResults {
-				break
-			}
-		}
 		close(resultChan)
 	}()
 
 	return resultChan
 }
 
+// Sends the q.MaxResults most recent messages, fetched with a single request
+func (client *Client) queryLatest(q common.Query, resultChan chan<- common.LogMessage) error {
+	allMessages, err := client.querySubIndex(client.Index, q)
+	if err != nil {
+		return err
+	}
+	for _, message := range allMessages {
+		resultChan <- message
+	}
+	return nil
+}
+
+// Pages through results using search_after starting after the given cursor (if
+// any), so memory use is bounded by the page size regardless of the number of results.
+func (client *Client) queryPaged(q common.Query, field, order string, searchAfter JsonList, resultChan chan<- common.LogMessage) error {
+	sentCount := 0
+	for {
+		hits, err := client.queryMessages(client.Index, q, searchPage{
+			field:       field,
+			order:       order,
+			size:        pageSize,
+			searchAfter: searchAfter,
+		})
+		if err != nil {
+			return err
+		}
+		for _, hit := range hits {
+			message, err := hitToMessage(hit, q)
+			if err != nil {
+				return err
+			}
+			resultChan <- message
+			sentCount++
+			if q.MaxResults > 0 && sentCount >= q.MaxResults {
+				return nil
+			}
+		}
+		if len(hits) < pageSize {
+			return nil
+		}
+		searchAfter = hits[len(hits)-1].Sort
+	}
+}
+
+// Returns the cursor of the hit right before the n most recent ones, or nil when
+// there are no more than n hits in total. Walks back from the most recent hit
+// without fetching any sources.
+func (client *Client) findCursorBefore(q common.Query, n int) (JsonList, error) {
+	var searchAfter JsonList
+	seenCount := 0
+	for {
+		hits, err := client.queryMessages(client.Index, q, searchPage{
+			order:       "desc",
+			size:        pageSize,
+			searchAfter: searchAfter,
+			noSource:    true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if seenCount+len(hits) > n {
+			return hits[n-seenCount].Sort, nil
+		}
+		if len(hits) < pageSize {
+			return nil, nil
+		}
+		seenCount += len(hits)
+		searchAfter = hits[len(hits)-1].Sort
+	}
+}
+
+func hitToMessage(hit Hit, q common.Query) (common.LogMessage, error) {
+	attributes := hit.Source
+	ts, err := time.Parse(time.RFC3339, attributes["@timestamp"].(string))
+	if err != nil {
+		return common.LogMessage{}, err
+	}
+	delete(attributes, "@timestamp")
+	message := common.FlattenLogMessage(common.LogMessage{
+		ID:         hit.ID,
+		Timestamp:  ts,
+		Attributes: attributes,
+	})
+	message.Attributes = q.Project(message.Attributes)
+	return message, nil
+}
+
+// Fetches the q.MaxResults most recent messages, returned oldest first
 func (client *Client) querySubIndex(subIndex string, q common.Query) ([]common.LogMessage, error) {
-	hits, err := client.queryMessages(subIndex, q)
+	hits, err := client.queryMessages(subIndex, q, searchPage{
+		order: "desc",
+		size:  q.MaxResults,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	allMessages := make([]common.LogMessage, 0, 200)
-	for _, hit := range hits {
-		//var ts time.Time
-		attributes := hit.Source
-		ts, err := time.Parse(time.RFC3339, attributes["@timestamp"].(string))
+	// Hits are sorted newest first, so reverse them
+	allMessages := make([]common.LogMessage, 0, len(hits))
+	for i := len(hits) - 1; i >= 0; i-- {
+		message, err := hitToMessage(hits[i], q)
 		if err != nil {
 			return nil, err
 		}
-		delete(attributes, "@timestamp")
-		message := common.FlattenLogMessage(common.LogMessage{
-			ID:         hit.ID,
-			Timestamp:  ts,
-			Attributes: attributes,
-		})
-		message.Attributes = common.Project(message.Attributes, q.SelectFields)
 		allMessages = append(allMessages, message)
 	}
 	return allMessages, nil
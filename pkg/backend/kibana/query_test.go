@@ -1,6 +1,12 @@
 package kibana
 
-import "testing"
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
 
 func TestProject(t *testing.T) {
 	/*myMap := project(map[string]interface{}{
@@ -18,3 +24,143 @@ func TestProject(t *testing.T) {
 	}
 	*/
 }
+
+func TestFilterQuery(t *testing.T) {
+	tests := []struct {
+		filter   common.QueryFilter
+		expected string
+		negate   bool
+	}{
+		{common.QueryFilter{FieldName: "level", Operator: "=", Value: "error"}, `{"match":{"level":{"query":"error","type":"phrase"}}}`, false},
+		{common.QueryFilter{FieldName: "level", Operator: "!=", Value: "error"}, `{"match":{"level":{"query":"error","type":"phrase"}}}`, true},
+		{common.QueryFilter{FieldName: "status", Operator: ">=", Value: "500"}, `{"range":{"status":{"gte":500}}}`, false},
+		{common.QueryFilter{FieldName: "duration_ms", Operator: "<", Value: "1.5"}, `{"range":{"duration_ms":{"lt":1.5}}}`, false},
+		{common.QueryFilter{FieldName: "created", Operator: ">", Value: "2017-09-04"}, `{"range":{"created":{"gt":"2017-09-04"}}}`, false},
+		{common.QueryFilter{FieldName: "path", Operator: "=~", Value: "^/api/v2/"}, `{"regexp":{"path":{"value":"/api/v2/.*"}}}`, false},
+		{common.QueryFilter{FieldName: "host", Operator: "!~", Value: "canary"}, `{"regexp":{"host":{"value":".*canary.*"}}}`, true},
+		{common.QueryFilter{FieldName: "env", Operator: "in", Values: []string{"prod", "staging"}}, `{"bool":{"minimum_should_match":1,"should":[{"match":{"env":{"query":"prod","type":"phrase"}}},{"match":{"env":{"query":"staging","type":"phrase"}}}]}}`, false},
+		{common.QueryFilter{FieldName: "code", Operator: "notin", Values: []string{"200"}}, `{"bool":{"minimum_should_match":1,"should":[{"match":{"code":{"query":"200","type":"phrase"}}}]}}`, true},
+		{common.QueryFilter{FieldName: "exception", Operator: "exists"}, `{"exists":{"field":"exception"}}`, false},
+		{common.QueryFilter{FieldName: "user_id", Operator: "missing"}, `{"exists":{"field":"user_id"}}`, true},
+		{common.QueryFilter{FieldName: "host", Operator: "=~", Value: `^web\d+$`}, `{"regexp":{"host":{"value":"web[0-9]+"}}}`, false},
+	}
+	for _, test := range tests {
+		clause, negate := filterQuery(test.filter)
+		if common.MustJsonEncode(clause) != test.expected || negate != test.negate {
+			t.Errorf("Wrong query for %+v: %s (negate: %v)", test.filter, common.MustJsonEncode(clause), negate)
+		}
+	}
+}
+
+func TestTermQuery(t *testing.T) {
+	tests := []struct {
+		term     string
+		expected string
+	}{
+		{`timeout`, `{"query_string":{"query":"\"timeout\""}}`},
+		{`"Connection  refused!"`, `{"query_string":{"query":"\"connection refused\""}}`},
+		{`db-1`, `{"query_string":{"query":"\"db 1\""}}`},
+		{`time*`, `{"query_string":{"analyze_wildcard":true,"query":"time*"}}`},
+		{`service:api`, `{"query_string":{"fields":["service"],"query":"\"api\""}}`},
+		{`/api/v*`, `{"query_string":{"analyze_wildcard":true,"default_operator":"AND","query":"api v*"}}`},
+	}
+	for _, test := range tests {
+		if query := common.MustJsonEncode(termQuery(common.ParseQueryTerm(test.term))); query != test.expected {
+			t.Errorf("Wrong query for %s: %s", test.term, query)
+		}
+	}
+}
+
+func TestEscapeLucene(t *testing.T) {
+	tests := []struct {
+		input, expected string
+		wildcards       bool
+	}{
+		{`a+b-c`, `a\+b\-c`, false},
+		{`(x OR y) && z || !w`, `\(x OR y\) \&\& z \|\| \!w`, false},
+		{`path:/api/[v1]`, `path\:\/api\/\[v1\]`, false},
+		{`{a^2~"b"}`, `\{a\^2\~\"b\"\}`, false},
+		{`c:\temp`, `c\:\\temp`, false},
+		{`a<b>c`, `a b c`, false},
+		{`time*?`, `time\*\?`, false},
+		{`time*?`, `time*?`, true},
+	}
+	for _, test := range tests {
+		if escaped := escapeLucene(test.input, test.wildcards); escaped != test.expected {
+			t.Errorf("Wrong escaping of %s: %s", test.input, escaped)
+		}
+	}
+	if quoted := quoteLucene(`say "hi" \o/`); quoted != `"say \"hi\" \\o/"` {
+		t.Errorf("Wrong quoting: %s", quoted)
+	}
+}
+
+func TestRawQueryString(t *testing.T) {
+	query := buildQuery(common.Query{QueryString: `status:[500 TO 599] AND NOT path:\/health`, RawQueryString: true})
+	expected := `{"bool":{"must":[{"query_string":{"analyze_wildcard":true,"query":"status:[500 TO 599] AND NOT path:\\/health"}}],"must_not":[]}}`
+	if encoded := common.MustJsonEncode(query); encoded != expected {
+		t.Errorf("Wrong query: %s", encoded)
+	}
+}
+
+func TestExcludePhrases(t *testing.T) {
+	query := buildQuery(common.Query{QueryString: "timeout", ExcludePhrases: []string{"Health check"}})
+	expected := `{"bool":{"must":[{"query_string":{"query":"\"timeout\""}}],"must_not":[{"query_string":{"query":"\"health check\""}}]}}`
+	if encoded := common.MustJsonEncode(query); encoded != expected {
+		t.Errorf("Wrong query: %s", encoded)
+	}
+}
+
+func TestCaseSensitiveExclusions(t *testing.T) {
+	expr, err := common.ParseExpression(`NOT "Health check" AND level=error`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := buildQuery(common.Query{QueryString: "Timeout", ExcludePhrases: []string{"Debug"}, Expression: expr, CaseSensitive: true})
+	expected := `{"bool":{"must":[{"query_string":{"query":"\"timeout\""}},` +
+		`{"bool":{"must":[{"bool":{"must_not":[{"match_none":{}}]}},{"match":{"level":{"query":"error","type":"phrase"}}}]}}],"must_not":[]}}`
+	if encoded := common.MustJsonEncode(query); encoded != expected {
+		t.Errorf("Wrong query: %s", encoded)
+	}
+}
+
+func TestExpressionQuery(t *testing.T) {
+	expr, err := common.ParseExpression(`(level=error OR level=fatal) AND NOT service=healthcheck AND level!=debug`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"bool":{"must":[` +
+		`{"bool":{"minimum_should_match":1,"should":[{"match":{"level":{"query":"error","type":"phrase"}}},{"match":{"level":{"query":"fatal","type":"phrase"}}}]}},` +
+		`{"bool":{"must_not":[{"match":{"service":{"query":"healthcheck","type":"phrase"}}}]}},` +
+		`{"bool":{"must_not":[{"match":{"level":{"query":"debug","type":"phrase"}}}]}}` +
+		`]}}`
+	if common.MustJsonEncode(expressionQuery(expr)) != expected {
+		t.Errorf("Wrong query: %s", common.MustJsonEncode(expressionQuery(expr)))
+	}
+}
+
+func TestCollectBuckets(t *testing.T) {
+	var aggregations JsonObject
+	err := json.Unmarshal([]byte(`{"by0": {"buckets": [
+		{"key": "api", "doc_count": 3, "by1": {"buckets": [
+			{"key": "error", "doc_count": 2},
+			{"key": "info", "doc_count": 1}]}},
+		{"key": "web", "doc_count": 5, "by1": {"buckets": [
+			{"key": "error", "doc_count": 4}]}, "missing1": {"doc_count": 1}}]},
+		"missing0": {"doc_count": 2, "by1": {"buckets": [
+			{"key": "info", "doc_count": 2}]}, "missing1": {"doc_count": 0}}}`), &aggregations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := collectBuckets(aggregations, 0, 2, []string{}, nil)
+	expected := []common.GroupCount{
+		{Values: []string{"api", "error"}, Count: 2},
+		{Values: []string{"api", "info"}, Count: 1},
+		{Values: []string{"web", "error"}, Count: 4},
+		{Values: []string{"web", common.MissingValue}, Count: 1},
+		{Values: []string{common.MissingValue, "info"}, Count: 2},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Wrong groups: %+v", groups)
+	}
+}
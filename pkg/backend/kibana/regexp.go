@@ -0,0 +1,264 @@
+package kibana
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+// Characters with a special meaning in Lucene regular expressions, including
+// the optional operators # @ & < > ~ that Elasticsearch enables by default
+const luceneRegexpReserved = `.?+*|{}[]()"\#@&<>~`
+
+var errAnchors = errors.New("^ and $ are only supported at the start and end of the pattern")
+
+// Translates one of our regular expressions into a Lucene regular expression,
+// as used by Elasticsearch's regexp query. Lucene patterns always have to match
+// a whole term, so unanchored patterns get .* added. On analyzed (text) fields
+// the terms are the words of the value, so the pattern is matched against each
+// word rather than the whole value. Classes like \d are expanded, as they're
+// only supported by recent Elasticsearch versions. Word boundaries can't be
+// translated and result in an error.
+func luceneRegexp(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	branches := []*syntax.Regexp{re}
+	if re.Op == syntax.OpAlternate {
+		// Each alternative may be anchored differently, e.g. ^a|b
+		branches = re.Sub
+	}
+	pieces := make([]string, 0, len(branches))
+	for _, branch := range branches {
+		piece, err := luceneBranch(branch)
+		if err != nil {
+			return "", err
+		}
+		pieces = append(pieces, piece)
+	}
+	if len(pieces) == 1 {
+		return pieces[0], nil
+	}
+	return fmt.Sprintf("(%s)", strings.Join(pieces, ")|(")), nil
+}
+
+func isBegin(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpBeginText || re.Op == syntax.OpBeginLine
+}
+
+func isEnd(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpEndText || re.Op == syntax.OpEndLine
+}
+
+// Translates a top level alternative, turning anchors into the whole term matching of Lucene
+func luceneBranch(re *syntax.Regexp) (string, error) {
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	prefix, suffix := ".*", ".*"
+	if len(subs) > 0 && isBegin(subs[0]) {
+		prefix = ""
+		subs = subs[1:]
+	}
+	if len(subs) > 0 && isEnd(subs[len(subs)-1]) {
+		suffix = ""
+		subs = subs[:len(subs)-1]
+	}
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	for _, sub := range subs {
+		s, err := luceneNode(sub)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(s)
+	}
+	sb.WriteString(suffix)
+	return sb.String(), nil
+}
+
+func luceneNode(re *syntax.Regexp) (string, error) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return "", nil
+	case syntax.OpLiteral:
+		var sb strings.Builder
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 {
+				sb.WriteString(foldedRune(r))
+			} else {
+				sb.WriteString(escapeRegexpRune(r))
+			}
+		}
+		return sb.String(), nil
+	case syntax.OpCharClass:
+		return luceneClass(re.Rune), nil
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return ".", nil
+	case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		return "", errAnchors
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return "", errors.New(`\b and \B are not supported by Elasticsearch`)
+	case syntax.OpCapture:
+		s, err := luceneNode(re.Sub[0])
+		return fmt.Sprintf("(%s)", s), err
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		s, err := luceneNode(re.Sub[0])
+		if err != nil {
+			return "", err
+		}
+		if !isSingle(re.Sub[0]) {
+			s = fmt.Sprintf("(%s)", s)
+		}
+		switch re.Op {
+		case syntax.OpStar:
+			return s + "*", nil
+		case syntax.OpPlus:
+			return s + "+", nil
+		case syntax.OpQuest:
+			return s + "?", nil
+		case syntax.OpRepeat:
+			switch {
+			case re.Max == -1:
+				return fmt.Sprintf("%s{%d,}", s, re.Min), nil
+			case re.Min == re.Max:
+				return fmt.Sprintf("%s{%d}", s, re.Min), nil
+			default:
+				return fmt.Sprintf("%s{%d,%d}", s, re.Min, re.Max), nil
+			}
+		}
+	case syntax.OpConcat, syntax.OpAlternate:
+		pieces := make([]string, 0, len(re.Sub))
+		for _, sub := range re.Sub {
+			s, err := luceneNode(sub)
+			if err != nil {
+				return "", err
+			}
+			pieces = append(pieces, s)
+		}
+		if re.Op == syntax.OpConcat {
+			return strings.Join(pieces, ""), nil
+		}
+		return fmt.Sprintf("(%s)", strings.Join(pieces, "|")), nil
+	}
+	return "", fmt.Errorf("Unsupported regular expression: %s", re)
+}
+
+// Whether a node translates to a single character or group, which a repetition can follow directly
+func isSingle(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune) == 1
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL, syntax.OpCapture:
+		return true
+	}
+	return false
+}
+
+func escapeRegexpRune(r rune) string {
+	if strings.ContainsRune(luceneRegexpReserved, r) {
+		return `\` + string(r)
+	}
+	return string(r)
+}
+
+// A class matching r in any case, e.g. [kKK] for k
+func foldedRune(r rune) string {
+	runes := []rune{r}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		runes = append(runes, f)
+	}
+	if len(runes) == 1 {
+		return escapeRegexpRune(r)
+	}
+	var sb strings.Builder
+	sb.WriteString("[")
+	for _, r := range runes {
+		sb.WriteString(escapeClassRune(r))
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+func escapeClassRune(r rune) string {
+	if strings.ContainsRune(luceneRegexpReserved, r) || r == '-' || r == '^' {
+		return `\` + string(r)
+	}
+	return string(r)
+}
+
+// Writes a class given as pairs of lower and upper bounds. Negated classes
+// such as [^a] or \D are parsed into ranges covering the rest of Unicode, so
+// they are written as negated classes again.
+func luceneClass(ranges []rune) string {
+	negated := len(ranges) > 0 && ranges[0] == 0 && ranges[len(ranges)-1] == unicode.MaxRune
+	if negated {
+		complement := make([]rune, 0, len(ranges))
+		for i := 1; i+1 < len(ranges); i += 2 {
+			complement = append(complement, ranges[i]+1, ranges[i+1]-1)
+		}
+		if len(complement) == 0 {
+			return "."
+		}
+		ranges = complement
+	}
+	var sb strings.Builder
+	sb.WriteString("[")
+	if negated {
+		sb.WriteString("^")
+	}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		sb.WriteString(escapeClassRune(ranges[i]))
+		if ranges[i+1] > ranges[i] {
+			sb.WriteString("-")
+			sb.WriteString(escapeClassRune(ranges[i+1]))
+		}
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// Filters of the query and its expression
+func queryFilters(q common.Query) []common.QueryFilter {
+	filters := append([]common.QueryFilter{}, q.Filters...)
+	var collect func(e common.Expression)
+	collect = func(e common.Expression) {
+		switch expr := e.(type) {
+		case common.AndExpression:
+			for _, operand := range expr.Operands {
+				collect(operand)
+			}
+		case common.OrExpression:
+			for _, operand := range expr.Operands {
+				collect(operand)
+			}
+		case common.NotExpression:
+			collect(expr.Operand)
+		case common.FilterExpression:
+			filters = append(filters, expr.Filter)
+		}
+	}
+	if q.Expression != nil {
+		collect(q.Expression)
+	}
+	return filters
+}
+
+// Checks that the regular expressions of a query can be sent to Elasticsearch
+func checkRegexps(q common.Query) error {
+	for _, filter := range queryFilters(q) {
+		if filter.Operator != "=~" && filter.Operator != "!~" {
+			continue
+		}
+		if _, err := luceneRegexp(filter.Value); err != nil {
+			return fmt.Errorf("Cannot filter on %s with Kibana: %v", filter, err)
+		}
+	}
+	return nil
+}
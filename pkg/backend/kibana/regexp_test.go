@@ -0,0 +1,50 @@
+package kibana
+
+import (
+	"testing"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+func TestLuceneRegexp(t *testing.T) {
+	tests := map[string]string{
+		`canary`:         `.*canary.*`,
+		`^/api/v2/`:      `/api/v2/.*`,
+		`^web\d+$`:       `web[0-9]+`,
+		`^a|b`:           `(a.*)|(.*b.*)`,
+		`^(v1|v2)$`:      `(v[1-2])`,
+		`user@host<1>`:   `.*user\@host\<1\>.*`,
+		`a#b&c~d`:        `.*a\#b\&c\~d.*`,
+		`\w+\.log`:       `.*[0-9A-Z_a-z]+\.log.*`,
+		`[^a-z]x{2,3}`:   `.*[^a-z]x{2,3}.*`,
+		`(?i)^error`:     `[Ee][Rr][Rr][Oo][Rr].*`,
+		`^(ab)+(?:cd)*$`: `(ab)+(cd)*`,
+		`^"quoted"\s?$`:  "\\\"quoted\\\"[\t-\n\f-\r ]?",
+		`^x{3,}\D$`:      `x{3,}[^0-9]`,
+	}
+	for pattern, expected := range tests {
+		translated, err := luceneRegexp(pattern)
+		if err != nil || translated != expected {
+			t.Errorf("Translated %s into %s (%v), expected %s", pattern, translated, err, expected)
+		}
+	}
+	for _, pattern := range []string{`\bword\b`, `a^b`, `a$b`, `(unclosed`} {
+		if translated, err := luceneRegexp(pattern); err == nil {
+			t.Errorf("Should not have translated %s: %s", pattern, translated)
+		}
+	}
+}
+
+func TestCheckRegexps(t *testing.T) {
+	expr, err := common.ParseExpression(`level=error OR NOT host=~\bcanary`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRegexps(common.Query{Expression: expr}); err == nil {
+		t.Error("Expected an error for a regular expression in an expression")
+	}
+	filter, _ := common.ParseQueryFilter("path=~^/api/")
+	if err := checkRegexps(common.Query{Filters: []common.QueryFilter{filter}}); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,8 +1,6 @@
 package stream
 
 import (
-	"bufio"
-
 	"io"
 
 	"encoding/json"
@@ -12,6 +10,7 @@ import (
 
 	"github.com/egnyte/ax/pkg/backend/common"
 	"github.com/egnyte/ax/pkg/heuristic"
+	"github.com/egnyte/ax/pkg/syslog"
 )
 
 type Client struct {
@@ -22,11 +21,41 @@ func New(file io.Reader) *Client {
 	return &Client{file}
 }
 
-func parseLine(line string) common.LogMessage {
+// Parses the first line of a record as JSON, with parser (if any), or as syslog
+// or logfmt, otherwise it is kept as message. Any further lines of the record (e.g. a
+// stack trace) are added to the message.
+func parseLine(record string, parser common.LineParser) common.LogMessage {
+	line, rest := record, ""
+	if i := strings.IndexByte(record, '\n'); i >= 0 {
+		line, rest = record[:i], record[i+1:]
+	}
+	message := parseFirstLine(line, parser)
+	if rest != "" {
+		if text, _ := message.Attributes["message"].(string); text != "" {
+			rest = text + "\n" + rest
+		}
+		message.Attributes["message"] = rest
+	}
+	return message
+}
+
+func parseFirstLine(line string, parser common.LineParser) common.LogMessage {
 	decoder := json.NewDecoder(strings.NewReader(line))
 	obj := make(map[string]interface{})
 	err := decoder.Decode(&obj)
 	if err != nil {
+		parsers := []common.LineParser{syslog.Parser{}, logfmtParser{}}
+		if parser != nil {
+			parsers = append([]common.LineParser{parser}, parsers...)
+		}
+		for _, p := range parsers {
+			if parsed, ok := p.ParseLine(line); ok {
+				return common.LogMessage{
+					Timestamp:  time.Now(),
+					Attributes: parsed,
+				}
+			}
+		}
 		obj["message"] = strings.TrimSpace(line)
 		return common.LogMessage{
 			Timestamp:  time.Now(),
@@ -39,22 +68,23 @@ func parseLine(line string) common.LogMessage {
 	}
 }
 
+func asContext(message common.LogMessage, q common.Query) common.LogMessage {
+	message.IsContext = true
+	message.Attributes = q.Project(message.Attributes)
+	return message
+}
+
 func (client *Client) Query(q common.Query) <-chan common.LogMessage {
 	resultChan := make(chan common.LogMessage)
-	reader := bufio.NewReader(client.reader)
+	records := readRecords(client.reader, q.RecordStart, flushTimeout)
+	matcher := q.Matcher()
 	go func() {
 		var ltFunc heuristic.LogTimestampParser
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				// TODO: erro != EOF break?
-				if err == io.EOF {
-					break
-				}
-				//fmt.Println("Error: ", err)
-				break
-			}
-			message := parseLine(line)
+		// Ring buffer of the most recent non-matching messages, for context before a match
+		contextBefore := make([]common.LogMessage, 0, q.ContextBefore)
+		contextAfterLeft := 0
+		for record := range records {
+			message := parseLine(record, q.Parser)
 			if ltFunc == nil {
 				ltFunc = heuristic.FindTimestampFunc(message)
 			}
@@ -70,15 +100,29 @@ func (client *Client) Query(q common.Query) <-chan common.LogMessage {
 					}
 				}
 			}
-			if common.MatchesQuery(message, q) {
-				message.Attributes = common.Project(message.Attributes, q.SelectFields)
+			if matcher.Matches(message) {
+				for _, contextMessage := range contextBefore {
+					resultChan <- contextMessage
+				}
+				contextBefore = contextBefore[:0]
+				message.Attributes = q.Project(message.Attributes)
 				resultChan <- message
+				contextAfterLeft = q.ContextAfter
+			} else if contextAfterLeft > 0 {
+				contextAfterLeft--
+				resultChan <- asContext(message, q)
+			} else if q.ContextBefore > 0 {
+				if len(contextBefore) == q.ContextBefore {
+					copy(contextBefore, contextBefore[1:])
+					contextBefore = contextBefore[:len(contextBefore)-1]
+				}
+				contextBefore = append(contextBefore, asContext(message, q))
 			}
 		}
 		close(resultChan)
 	}()
 
-	return resultChan
+	return common.SortMessages(resultChan, q)
 }
 
 var _ common.Client = &Client{}
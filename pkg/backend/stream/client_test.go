@@ -1,6 +1,7 @@
 package stream
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -46,3 +47,99 @@ func TestFindTimestampInMessage(t *testing.T) {
 	}
 
 }
+
+func TestContext(t *testing.T) {
+	sampleData := `{"message": "1"}
+{"message": "2"}
+{"message": "3 error"}
+{"message": "4"}
+{"message": "5"}
+{"message": "6"}
+{"message": "7 error"}
+{"message": "8 error"}
+{"message": "9"}
+{"message": "10"}
+`
+	sc := New(strings.NewReader(sampleData))
+	expected := []string{"2", "3 error", "4", "6", "7 error", "8 error", "9"}
+	expectedContext := []bool{true, false, true, true, false, false, true}
+	counter := 0
+	for msg := range sc.Query(common.Query{QueryString: "error", ContextBefore: 1, ContextAfter: 1}) {
+		if counter >= len(expected) {
+			t.Fatal("Too many messages", msg.Attributes["message"])
+		}
+		if msg.Attributes["message"] != expected[counter] || msg.IsContext != expectedContext[counter] {
+			t.Errorf("Expected %s (context: %v), got %s (context: %v)", expected[counter], expectedContext[counter], msg.Attributes["message"], msg.IsContext)
+		}
+		counter++
+	}
+	if counter != len(expected) {
+		t.Errorf("Expected %d messages, got %d", len(expected), counter)
+	}
+}
+
+type prefixParser struct{}
+
+func (prefixParser) ParseLine(line string) (map[string]interface{}, bool) {
+	pieces := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if len(pieces) != 2 || pieces[0] != strings.ToUpper(pieces[0]) {
+		return nil, false
+	}
+	return map[string]interface{}{"level": pieces[0], "message": pieces[1]}, true
+}
+
+func TestParser(t *testing.T) {
+	sampleData := `ERROR disk full
+{"level": "INFO", "message": "json"}
+plain text
+`
+	sc := New(strings.NewReader(sampleData))
+	expected := []string{"disk full", "json", "plain text"}
+	expectedLevels := []interface{}{"ERROR", "INFO", nil}
+	counter := 0
+	for msg := range sc.Query(common.Query{Parser: prefixParser{}}) {
+		if msg.Attributes["message"] != expected[counter] || msg.Attributes["level"] != expectedLevels[counter] {
+			t.Errorf("Wrong message %d: %+v", counter, msg.Attributes)
+		}
+		counter++
+	}
+	if counter != len(expected) {
+		t.Errorf("Expected %d messages, got %d", len(expected), counter)
+	}
+}
+
+func TestMultiLineMessages(t *testing.T) {
+	sampleData := `ERROR Could not sync
+Traceback (most recent call last):
+  File "sync.py", line 3, in <module>
+ValueError: bad value
+INFO Done
+`
+	sc := New(strings.NewReader(sampleData))
+	messages := make([]string, 0)
+	for msg := range sc.Query(common.Query{QueryString: "Traceback"}) {
+		messages = append(messages, msg.Attributes["message"].(string))
+	}
+	expected := []string{"ERROR Could not sync\nTraceback (most recent call last):\n  File \"sync.py\", line 3, in <module>\nValueError: bad value"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Wrong messages: %q", messages)
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	sampleData := `Oct  3 08:12:01 web-1 sshd[4121]: Accepted publickey for deploy
+<34>1 2017-10-11T22:14:15.003Z mymachine su - ID47 - 'su root' failed
+`
+	sc := New(strings.NewReader(sampleData))
+	hosts := []string{"web-1", "mymachine"}
+	counter := 0
+	for msg := range sc.Query(common.Query{}) {
+		if msg.Attributes["hostname"] != hosts[counter] || msg.Timestamp.Month() != time.October {
+			t.Errorf("Wrong message: %v %+v", msg.Timestamp, msg.Attributes)
+		}
+		counter++
+	}
+	if counter != len(hosts) {
+		t.Errorf("Expected %d messages, got %d", len(hosts), counter)
+	}
+}
@@ -0,0 +1,23 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/backend/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T, messages []common.LogMessage) common.Client {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		for _, message := range messages {
+			if err := encoder.Encode(message.Attributes); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return New(&buf)
+	})
+}
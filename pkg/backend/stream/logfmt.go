@@ -0,0 +1,68 @@
+package stream
+
+import (
+	"strconv"
+	"strings"
+)
+
+type logfmtParser struct{}
+
+func (logfmtParser) ParseLine(line string) (map[string]interface{}, bool) {
+	return parseLogfmt(line)
+}
+
+// Parses a logfmt line, e.g. level=info msg="request done" dur=12ms. Returns
+// false unless the whole line consists of key=value pairs, so plain text that
+// happens to contain an = isn't mistaken for logfmt.
+func parseLogfmt(line string) (map[string]interface{}, bool) {
+	line = strings.TrimSpace(line)
+	attributes := make(map[string]interface{})
+	for line != "" {
+		separator := strings.IndexAny(line, "= \t\"")
+		if separator <= 0 || line[separator] != '=' {
+			return nil, false
+		}
+		key := line[:separator]
+		line = line[separator+1:]
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := closingQuote(line)
+			if end < 0 {
+				return nil, false
+			}
+			unquoted, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return nil, false
+			}
+			value, line = unquoted, line[end+1:]
+			if line != "" && line[0] != ' ' && line[0] != '\t' {
+				return nil, false
+			}
+		} else {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			value, line = line[:end], line[end:]
+			if strings.Contains(value, `"`) {
+				return nil, false
+			}
+		}
+		attributes[key] = value
+		line = strings.TrimLeft(line, " \t")
+	}
+	return attributes, len(attributes) > 0
+}
+
+// Index of the quote closing the string s starts with, -1 if there is none
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,34 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		`level=info msg="request done" dur=12ms`: {"level": "info", "msg": "request done", "dur": "12ms"},
+		"at=error  code=H12\tpath=/api?a=b \n":   {"at": "error", "code": "H12", "path": "/api?a=b"},
+		`msg="say \"hi\"\nbye" empty= q=""`:      {"msg": "say \"hi\"\nbye", "empty": "", "q": ""},
+		`service.name=api`:                       {"service.name": "api"},
+	}
+	for line, expected := range tests {
+		attributes, ok := parseLogfmt(line)
+		if !ok || !reflect.DeepEqual(attributes, expected) {
+			t.Errorf("Parsed %q as %+v", line, attributes)
+		}
+	}
+	for _, line := range []string{
+		``,
+		`Connection refused, retry=3`,
+		`x == y`,
+		`msg="unterminated`,
+		`msg="no space"after`,
+		`a=b"c`,
+		`GET /api?a=b 200`,
+	} {
+		if attributes, ok := parseLogfmt(line); ok {
+			t.Errorf("Should not have parsed %q: %+v", line, attributes)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package stream
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// How long to wait for more lines of a record, e.g. when following a process
+// that is in the middle of writing a stack trace
+const flushTimeout = time.Second
+
+// Lines continuing the record before them: indented lines (Java's "at ..."),
+// Java exceptions (java.io.IOException: ...) and their causes, and Python
+// traceback headers
+var continuationRegex = regexp.MustCompile(`^(\s+\S|Caused by:|[\w$]+(\.[\w$]+)+(Exception|Error)\b|Traceback \(most recent call last\):|\.\.\. \d+ more)`)
+
+const tracebackHeader = "Traceback (most recent call last):"
+
+// Lines between the tracebacks of chained Python exceptions, which are
+// surrounded by blank lines
+var chainedExceptionRegex = regexp.MustCompile(`^(During handling of the above exception, another exception occurred:|The above exception was the direct cause of the following exception:)`)
+
+// Joins lines into records, so that stack traces and other multi-line messages
+// become a single message
+type assembler struct {
+	// Lines matching any of these start a new record, other lines continue the
+	// previous one. Heuristics are used when empty.
+	recordStart []*regexp.Regexp
+	pending     []string
+	// Python tracebacks end with an unindented line with the exception
+	inTraceback bool
+	// A Python traceback or chained exception line was just added, so a
+	// chained exception may follow after blank lines
+	afterTraceback bool
+	// The last line added was a chained exception line
+	chained bool
+	// Blank lines after a traceback, which are only part of the record when a
+	// chained exception follows
+	held []string
+}
+
+func (a *assembler) startsRecord(line string) bool {
+	if len(a.pending) == 0 {
+		return true
+	}
+	if len(a.recordStart) > 0 {
+		for _, regex := range a.recordStart {
+			if regex.MatchString(line) {
+				return true
+			}
+		}
+		return false
+	}
+	if strings.TrimSpace(line) == "" {
+		return true
+	}
+	if a.inTraceback {
+		return false
+	}
+	return !continuationRegex.MatchString(line)
+}
+
+// Adds a line, returning the records completed by it
+func (a *assembler) add(line string) []string {
+	line = strings.TrimRight(line, "\r\n")
+	if a.afterTraceback {
+		if strings.TrimSpace(line) == "" {
+			a.held = append(a.held, line)
+			return nil
+		}
+		if chainedExceptionRegex.MatchString(line) || (a.chained && strings.HasPrefix(line, tracebackHeader)) {
+			a.pending = append(append(a.pending, a.held...), line)
+			a.held = a.held[:0]
+			a.chained = !a.chained
+			a.afterTraceback = a.chained
+			a.inTraceback = !a.chained
+			return nil
+		}
+	}
+	var records []string
+	if len(a.held) > 0 || a.startsRecord(line) {
+		// Blank lines always started a record of their own
+		records = a.flush()
+	}
+	a.afterTraceback = false
+	a.pending = append(a.pending, line)
+	if strings.HasPrefix(line, tracebackHeader) {
+		a.inTraceback = true
+	} else if a.inTraceback && !continuationRegex.MatchString(line) {
+		a.inTraceback = false
+		a.afterTraceback = len(a.recordStart) == 0
+	}
+	return records
+}
+
+// Returns the record being assembled and blank lines held after it (which are
+// records of their own), if any
+func (a *assembler) flush() []string {
+	records := make([]string, 0, 1+len(a.held))
+	if len(a.pending) > 0 {
+		records = append(records, strings.Join(a.pending, "\n"))
+	}
+	records = append(records, a.held...)
+	a.pending = a.pending[:0]
+	a.held = a.held[:0]
+	a.inTraceback, a.afterTraceback, a.chained = false, false, false
+	return records
+}
+
+// Reads records from r, records still being assembled are sent once no new
+// lines have come in for timeout
+func readRecords(r io.Reader, recordStart []*regexp.Regexp, timeout time.Duration) <-chan string {
+	lines := make(chan string)
+	go func() {
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			lines <- line
+		}
+		close(lines)
+	}()
+	records := make(chan string)
+	go func() {
+		a := &assembler{recordStart: recordStart}
+		timer := time.NewTimer(timeout)
+		timer.Stop()
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					for _, record := range a.flush() {
+						records <- record
+					}
+					close(records)
+					return
+				}
+				for _, record := range a.add(line) {
+					records <- record
+				}
+				// Stop and drain the timer first, so that it can't fire for an earlier line
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(timeout)
+			case <-timer.C:
+				for _, record := range a.flush() {
+					records <- record
+				}
+			}
+		}
+	}()
+	return records
+}
@@ -0,0 +1,119 @@
+package stream
+
+import (
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assemble(input string, recordStart []*regexp.Regexp) []string {
+	records := make([]string, 0)
+	for record := range readRecords(strings.NewReader(input), recordStart, time.Minute) {
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAssembleStackTraces(t *testing.T) {
+	input := `2017-10-17 10:00:00 ERROR Request failed
+java.lang.IllegalStateException: boom
+	at com.example.Api.handle(Api.java:10)
+	at com.example.Server.run(Server.java:20)
+Caused by: java.io.IOException: closed
+	at com.example.Db.query(Db.java:5)
+	... 2 more
+2017-10-17 10:00:01 INFO Next
+ERROR:root:Could not sync
+Traceback (most recent call last):
+  File "sync.py", line 3, in <module>
+    sync()
+ValueError: bad value
+INFO:root:Done
+
+{"message": "json"}
+`
+	expected := []string{
+		"2017-10-17 10:00:00 ERROR Request failed\njava.lang.IllegalStateException: boom\n\tat com.example.Api.handle(Api.java:10)\n\tat com.example.Server.run(Server.java:20)\n" +
+			"Caused by: java.io.IOException: closed\n\tat com.example.Db.query(Db.java:5)\n\t... 2 more",
+		"2017-10-17 10:00:01 INFO Next",
+		"ERROR:root:Could not sync\nTraceback (most recent call last):\n  File \"sync.py\", line 3, in <module>\n    sync()\nValueError: bad value",
+		"INFO:root:Done",
+		"",
+		`{"message": "json"}`,
+	}
+	if records := assemble(input, nil); !reflect.DeepEqual(records, expected) {
+		t.Errorf("Wrong records: %q", records)
+	}
+}
+
+func TestAssembleChainedExceptions(t *testing.T) {
+	input := `Traceback (most recent call last):
+  File "sync.py", line 3, in <module>
+    sync()
+KeyError: 'id'
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "sync.py", line 5, in <module>
+    raise ValueError("bad value")
+ValueError: bad value
+The above exception was the direct cause of the following exception:
+
+Traceback (most recent call last):
+  File "sync.py", line 7, in <module>
+RuntimeError: sync failed
+
+INFO:root:Done
+`
+	expected := []string{
+		"Traceback (most recent call last):\n  File \"sync.py\", line 3, in <module>\n    sync()\nKeyError: 'id'\n\n" +
+			"During handling of the above exception, another exception occurred:\n\n" +
+			"Traceback (most recent call last):\n  File \"sync.py\", line 5, in <module>\n    raise ValueError(\"bad value\")\nValueError: bad value\n" +
+			"The above exception was the direct cause of the following exception:\n\n" +
+			"Traceback (most recent call last):\n  File \"sync.py\", line 7, in <module>\nRuntimeError: sync failed",
+		"",
+		"INFO:root:Done",
+	}
+	if records := assemble(input, nil); !reflect.DeepEqual(records, expected) {
+		t.Errorf("Wrong records: %q", records)
+	}
+}
+
+func TestAssembleRecordStart(t *testing.T) {
+	input := `2017-10-17 10:00:00 ERROR Request failed
+java.lang.IllegalStateException: boom
+	at com.example.Api.handle(Api.java:10)
+
+2017-10-17 10:00:01 INFO Next
+`
+	expected := []string{
+		"2017-10-17 10:00:00 ERROR Request failed\njava.lang.IllegalStateException: boom\n\tat com.example.Api.handle(Api.java:10)\n",
+		"2017-10-17 10:00:01 INFO Next",
+	}
+	records := assemble(input, []*regexp.Regexp{regexp.MustCompile(`^\d{4}-`)})
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Wrong records: %q", records)
+	}
+}
+
+func TestFlushTimeout(t *testing.T) {
+	reader, writer := io.Pipe()
+	records := readRecords(reader, nil, 10*time.Millisecond)
+	io.WriteString(writer, "Exception in thread main\n\tat Main.main(Main.java:1)\n")
+	select {
+	case record := <-records:
+		if record != "Exception in thread main\n\tat Main.main(Main.java:1)" {
+			t.Errorf("Wrong record: %q", record)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Record was not flushed")
+	}
+	writer.Close()
+	if _, ok := <-records; ok {
+		t.Error("Expected no more records")
+	}
+}
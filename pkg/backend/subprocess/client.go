@@ -28,19 +28,22 @@ func (client *SubprocessClient) Query(query common.Query) <-chan common.LogMessa
 	}
 	resultChan := make(chan common.LogMessage)
 	go func() {
-		stdOutQuery := stdOutStream.Query(query)
-		stdErrQuery := stdErrStream.Query(query)
+		stdOutQuery := stdOutStream.Query(query.Unsorted())
+		stdErrQuery := stdErrStream.Query(query.Unsorted())
 		closed := 0
 		for closed < 2 {
 			select {
 			case message, ok := <-stdOutQuery:
 				if !ok {
+					// Receiving from a closed channel never blocks, so stop selecting it
+					stdOutQuery = nil
 					closed++
 					continue
 				}
 				resultChan <- message
 			case message, ok := <-stdErrQuery:
 				if !ok {
+					stdErrQuery = nil
 					closed++
 					continue
 				}
@@ -52,7 +55,7 @@ func (client *SubprocessClient) Query(query common.Query) <-chan common.LogMessa
 			panic(err)
 		}
 	}()
-	return resultChan
+	return common.SortMessages(resultChan, query)
 }
 
 func New(command []string) *SubprocessClient {
@@ -0,0 +1,33 @@
+package subprocess
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/backend/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T, messages []common.LogMessage) common.Client {
+		file, err := ioutil.TempFile("", "ax-conformance")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		encoder := json.NewEncoder(file)
+		for _, message := range messages {
+			if err := encoder.Encode(message.Attributes); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Left behind until the test is done, as the command runs when querying
+		name := file.Name()
+		t.Cleanup(func() {
+			os.Remove(name)
+		})
+		return New([]string{"cat", name})
+	})
+}
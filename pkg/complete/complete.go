@@ -1,7 +1,10 @@
 package complete
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"log"
@@ -13,10 +16,49 @@ import (
 
 const cacheFilename = "attribute-cache.json"
 
+// Only a handful of short values are offered per attribute, attributes with
+// more distinct values than maxTrackedValues (IDs, timestamps, free text) are
+// considered high cardinality and no values are remembered for them at all
+const maxValuesPerAttribute = 20
+const maxTrackedValues = 50
+const maxValueLength = 50
+
+// Values not seen for this long are forgotten
+const valueExpiry = 7 * 24 * time.Hour
+
+// How often values are seeded from server side aggregations, if supported
+const seedInterval = 24 * time.Hour
+
+// Maximum number of attributes to try seeding values for, including those
+// that can't be aggregated on
+const maxSeededAttributes = 20
+
+// Seeding happens while completing, so it gives up after this long
+const seedTimeout = 2 * time.Second
+
+type valueStats struct {
+	Count    int   `json:"count"`
+	LastSeen int64 `json:"seen"`
+}
+
+type attributeValues struct {
+	Values          map[string]*valueStats `json:"values"`
+	HighCardinality bool                   `json:"high_cardinality,omitempty"`
+	// When the attribute was found to be high cardinality, which is
+	// reconsidered after valueExpiry like values are
+	HighCardinalitySince int64 `json:"high_cardinality_since,omitempty"`
+}
+
+func valuesKey(rc config.RuntimeConfig) string {
+	return fmt.Sprintf("values:%s", rc.ActiveEnv)
+}
+
 func GatherCompletionInfo(rc config.RuntimeConfig, messages <-chan common.LogMessage) <-chan common.LogMessage {
 	cache := cache.New(fmt.Sprintf("%s/%s", rc.DataDir, cacheFilename))
 	completionsKey := fmt.Sprintf("completions:%s", rc.ActiveEnv)
 	attrNames := make(map[string]bool)
+	attrValues := valuesFromCache(cache.Get(valuesKey(rc)))
+	pruneValues(attrValues, time.Now())
 
 	// This will be read back as a map[string]interface{} not a bool
 	if existingAttributes, ok := cache.Get(completionsKey).(map[string]interface{}); ok {
@@ -25,18 +67,26 @@ func GatherCompletionInfo(rc config.RuntimeConfig, messages <-chan common.LogMes
 			attrNames[existingAttr] = true
 		}
 	}
+	// Guards attrNames, attrValues and changed, which are encoded while flushing
+	var mutex sync.Mutex
 	changed := true
 	stopFlushing := make(chan struct{})
 	resultChan := make(chan common.LogMessage)
 	go func() {
 		for message := range messages {
 			resultChan <- message
-			for k, _ := range message.Attributes {
+			now := time.Now()
+			mutex.Lock()
+			for k, v := range message.Attributes {
 				if !attrNames[k] {
 					attrNames[k] = true
 					changed = true
 				}
+				if recordValue(attrValues, k, v, 1, now) {
+					changed = true
+				}
 			}
+			mutex.Unlock()
 		}
 		close(resultChan)
 		stopFlushing <- struct{}{}
@@ -50,15 +100,18 @@ func GatherCompletionInfo(rc config.RuntimeConfig, messages <-chan common.LogMes
 				shouldBreak = true
 			case <-time.After(5 * time.Second):
 			}
+			mutex.Lock()
 			if changed {
 				log.Println("Flushing cache")
 				cache.Set(completionsKey, attrNames, nil)
+				cache.Set(valuesKey(rc), attrValues, nil)
 				err := cache.Flush()
 				if err != nil {
 					log.Println("Could not flush cache:", err)
 				}
 				changed = false
 			}
+			mutex.Unlock()
 			if shouldBreak {
 				break
 			}
@@ -68,6 +121,103 @@ func GatherCompletionInfo(rc config.RuntimeConfig, messages <-chan common.LogMes
 	return resultChan
 }
 
+// Counts count occurrences of a value of an attribute, returns whether anything was recorded
+func recordValue(attrValues map[string]*attributeValues, attrName string, v interface{}, count int, now time.Time) bool {
+	var value string
+	switch v.(type) {
+	case string, float64, bool:
+		value = fmt.Sprintf("%v", v)
+	default:
+		return false
+	}
+	if value == "" || len(value) > maxValueLength {
+		return false
+	}
+	values, ok := attrValues[attrName]
+	if !ok {
+		values = &attributeValues{Values: make(map[string]*valueStats)}
+		attrValues[attrName] = values
+	}
+	if values.HighCardinality {
+		return false
+	}
+	stats, ok := values.Values[value]
+	if !ok {
+		if len(values.Values) >= maxTrackedValues {
+			values.HighCardinality = true
+			values.HighCardinalitySince = now.Unix()
+			values.Values = make(map[string]*valueStats)
+			return true
+		}
+		stats = &valueStats{}
+		values.Values[value] = stats
+	}
+	stats.Count += count
+	stats.LastSeen = now.Unix()
+	return true
+}
+
+// Forgets values that haven't been seen in a while, and that attributes have
+// high cardinality if that was found out a while ago
+func pruneValues(attrValues map[string]*attributeValues, now time.Time) {
+	expired := now.Add(-valueExpiry).Unix()
+	for attrName, values := range attrValues {
+		if values.HighCardinality && values.HighCardinalitySince < expired {
+			values.HighCardinality = false
+			values.HighCardinalitySince = 0
+		}
+		for value, stats := range values.Values {
+			if stats.LastSeen < expired {
+				delete(values.Values, value)
+			}
+		}
+		if len(values.Values) == 0 && !values.HighCardinality {
+			delete(attrValues, attrName)
+		}
+	}
+}
+
+// Values will be read back as generic maps, so they are converted through JSON
+func valuesFromCache(res interface{}) map[string]*attributeValues {
+	result := make(map[string]*attributeValues)
+	if res == nil {
+		return result
+	}
+	buf, err := json.Marshal(res)
+	if err == nil {
+		err = json.Unmarshal(buf, &result)
+	}
+	if err != nil {
+		log.Println("Could not read cached values:", err)
+		return make(map[string]*attributeValues)
+	}
+	for _, values := range result {
+		if values.Values == nil {
+			values.Values = make(map[string]*valueStats)
+		}
+	}
+	return result
+}
+
+// The most frequently seen values of an attribute
+func topValues(values *attributeValues) []string {
+	result := make([]string, 0, len(values.Values))
+	for value, _ := range values.Values {
+		result = append(result, value)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := values.Values[result[i]], values.Values[result[j]]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return result[i] < result[j]
+	})
+	if len(result) > maxValuesPerAttribute {
+		result = result[:maxValuesPerAttribute]
+	}
+	return result
+}
+
 func GetCompletions(rc config.RuntimeConfig) map[string]bool {
 	cache := cache.New(fmt.Sprintf("%s/%s", rc.DataDir, cacheFilename))
 	res := cache.Get(fmt.Sprintf("completions:%s", rc.ActiveEnv))
@@ -79,3 +229,82 @@ func GetCompletions(rc config.RuntimeConfig) map[string]bool {
 	}
 	return result
 }
+
+// Returns the values most frequently seen per attribute, used to complete e.g. --where level=
+func GetValueCompletions(rc config.RuntimeConfig) map[string][]string {
+	cache := cache.New(fmt.Sprintf("%s/%s", rc.DataDir, cacheFilename))
+	attrValues := valuesFromCache(cache.Get(valuesKey(rc)))
+	pruneValues(attrValues, time.Now())
+	result := make(map[string][]string)
+	for attrName, values := range attrValues {
+		if top := topValues(values); len(top) > 0 {
+			result[attrName] = top
+		}
+	}
+	return result
+}
+
+// Seeds values of known attributes from the last hour of logs using server
+// side aggregations, at most once a day. Attributes already known to have
+// many distinct values are skipped. As this runs while completing, it stops
+// after seedTimeout, keeping the values counted so far.
+func SeedValues(rc config.RuntimeConfig, counter common.GroupCounter) {
+	cache := cache.New(fmt.Sprintf("%s/%s", rc.DataDir, cacheFilename))
+	seededKey := fmt.Sprintf("seeded:%s", rc.ActiveEnv)
+	if cache.Contains(seededKey) {
+		return
+	}
+	attrValues := valuesFromCache(cache.Get(valuesKey(rc)))
+	pruneValues(attrValues, time.Now())
+	seedValues(counter, GetCompletions(rc), attrValues, time.Now(), seedTimeout)
+	expire := time.Now().Add(seedInterval)
+	cache.Set(seededKey, true, &expire)
+	cache.Set(valuesKey(rc), attrValues, nil)
+	if err := cache.Flush(); err != nil {
+		log.Println("Could not flush cache:", err)
+	}
+}
+
+type countResult struct {
+	groups []common.GroupCount
+	err    error
+}
+
+// Counts values of up to maxSeededAttributes of attrNames into attrValues,
+// giving up on the remaining attributes when timeout has passed
+func seedValues(counter common.GroupCounter, attrNames map[string]bool, attrValues map[string]*attributeValues, now time.Time, timeout time.Duration) {
+	after := now.Add(-time.Hour)
+	query := common.Query{After: &after, Before: &now}
+	deadline := time.After(timeout)
+	attempts := 0
+	for attrName, _ := range attrNames {
+		if values, ok := attrValues[attrName]; ok && values.HighCardinality {
+			continue
+		}
+		if attempts >= maxSeededAttributes {
+			return
+		}
+		attempts++
+		results := make(chan countResult, 1)
+		go func(attrName string) {
+			groups, err := counter.CountBy(query, []string{attrName}, maxTrackedValues+1)
+			results <- countResult{groups, err}
+		}(attrName)
+		var result countResult
+		select {
+		case result = <-results:
+		case <-deadline:
+			log.Println("Timed out seeding values")
+			return
+		}
+		if result.err != nil {
+			// Not all attributes can be aggregated on, e.g. analyzed text fields
+			continue
+		}
+		for _, group := range result.groups {
+			if group.Values[0] != common.MissingValue {
+				recordValue(attrValues, attrName, group.Values[0], group.Count, now)
+			}
+		}
+	}
+}
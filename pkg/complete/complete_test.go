@@ -0,0 +1,114 @@
+package complete
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+var now = time.Date(2017, 10, 17, 14, 30, 0, 0, time.UTC)
+
+func TestRecordValue(t *testing.T) {
+	attrValues := make(map[string]*attributeValues)
+	for i := 0; i < 5; i++ {
+		recordValue(attrValues, "level", "info", 1, now)
+	}
+	recordValue(attrValues, "level", "error", 3, now)
+	recordValue(attrValues, "level", "warn", 1, now)
+	recordValue(attrValues, "nested", map[string]interface{}{}, 1, now)
+	if top := topValues(attrValues["level"]); !reflect.DeepEqual(top, []string{"info", "error", "warn"}) {
+		t.Errorf("Wrong top values: %v", top)
+	}
+	if _, ok := attrValues["nested"]; ok {
+		t.Error("Should not record values of objects")
+	}
+}
+
+func TestRecordValueHighCardinality(t *testing.T) {
+	attrValues := make(map[string]*attributeValues)
+	for i := 0; i <= maxTrackedValues; i++ {
+		recordValue(attrValues, "request_id", fmt.Sprintf("req-%d", i), 1, now)
+	}
+	if values := attrValues["request_id"]; !values.HighCardinality || len(values.Values) != 0 {
+		t.Errorf("Expected request_id to be high cardinality without values: %+v", values)
+	}
+	if recordValue(attrValues, "request_id", "req-1", 1, now) {
+		t.Error("Should not record values of high cardinality attributes")
+	}
+}
+
+func TestPruneValues(t *testing.T) {
+	attrValues := make(map[string]*attributeValues)
+	recordValue(attrValues, "level", "info", 1, now.Add(-30*24*time.Hour))
+	recordValue(attrValues, "level", "error", 1, now)
+	recordValue(attrValues, "host", "old", 1, now.Add(-30*24*time.Hour))
+	pruneValues(attrValues, now)
+	if top := topValues(attrValues["level"]); !reflect.DeepEqual(top, []string{"error"}) {
+		t.Errorf("Expected old values to be pruned: %v", top)
+	}
+	if _, ok := attrValues["host"]; ok {
+		t.Error("Expected attribute without values to be pruned")
+	}
+}
+
+func TestPruneHighCardinality(t *testing.T) {
+	attrValues := make(map[string]*attributeValues)
+	for i := 0; i <= maxTrackedValues; i++ {
+		recordValue(attrValues, "request_id", fmt.Sprintf("req-%d", i), 1, now.Add(-30*24*time.Hour))
+	}
+	pruneValues(attrValues, now)
+	if _, ok := attrValues["request_id"]; ok {
+		t.Error("Expected high cardinality to be forgotten after a while")
+	}
+}
+
+type fakeCounter struct {
+	calls int
+	delay time.Duration
+	err   error
+}
+
+func (c *fakeCounter) CountBy(q common.Query, fields []string, top int) ([]common.GroupCount, error) {
+	c.calls++
+	time.Sleep(c.delay)
+	return []common.GroupCount{{Values: []string{"info"}, Count: 3}}, c.err
+}
+
+func TestSeedValues(t *testing.T) {
+	attrNames := make(map[string]bool)
+	for i := 0; i < 2*maxSeededAttributes; i++ {
+		attrNames[fmt.Sprintf("attr%d", i)] = true
+	}
+	// Failed attempts count towards the limit too
+	failing := &fakeCounter{err: errors.New("text field")}
+	seedValues(failing, attrNames, make(map[string]*attributeValues), now, time.Second)
+	if failing.calls != maxSeededAttributes {
+		t.Errorf("Expected %d attempts, got %d", maxSeededAttributes, failing.calls)
+	}
+
+	slow := &fakeCounter{delay: 50 * time.Millisecond}
+	attrValues := make(map[string]*attributeValues)
+	seedValues(slow, attrNames, attrValues, now, 120*time.Millisecond)
+	if len(attrValues) < 1 || len(attrValues) > 3 {
+		t.Errorf("Expected seeding to stop after the timeout, seeded %d attributes", len(attrValues))
+	}
+}
+
+func TestValuesFromCache(t *testing.T) {
+	attrValues := make(map[string]*attributeValues)
+	recordValue(attrValues, "level", "info", 2, now)
+	// Simulate the round trip through the JSON cache file
+	var cached interface{}
+	buf, _ := json.Marshal(attrValues)
+	if err := json.Unmarshal(buf, &cached); err != nil {
+		t.Fatal(err)
+	}
+	if restored := valuesFromCache(cached); !reflect.DeepEqual(restored, attrValues) {
+		t.Errorf("Values changed going through the cache: %+v", restored)
+	}
+}
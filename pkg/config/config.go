@@ -30,6 +30,9 @@ type Config struct {
 	DefaultEnv   string            `yaml:"default"`
 	Environments map[string]EnvMap `yaml:"env"`
 	Alerts       []AlertConfig     `yaml:"alerts"`
+	Saved        []SavedQuery      `yaml:"saved,omitempty"`
+	// Grok patterns in addition to the built-in ones, by name
+	GrokPatterns map[string]string `yaml:"grok_patterns,omitempty"`
 }
 
 type AlertConfig struct {
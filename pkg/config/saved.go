@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+// A named query, optionally bound to an environment. Selectors may contain
+// parameters such as {{service}}, which are filled in when running it.
+type SavedQuery struct {
+	Name     string                `yaml:"name"`
+	Env      string                `yaml:"env,omitempty"`
+	Selector common.QuerySelectors `yaml:"selector"`
+}
+
+var paramRegex = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+func (config Config) FindSavedQuery(name string) *SavedQuery {
+	for i := range config.Saved {
+		if config.Saved[i].Name == name {
+			return &config.Saved[i]
+		}
+	}
+	return nil
+}
+
+// Adds a saved query, replacing an existing one with the same name
+func (config *Config) SaveQuery(saved SavedQuery) {
+	if existing := config.FindSavedQuery(saved.Name); existing != nil {
+		*existing = saved
+		return
+	}
+	config.Saved = append(config.Saved, saved)
+}
+
+// Removes a saved query, returns whether it existed
+func (config *Config) RemoveSavedQuery(name string) bool {
+	for i, saved := range config.Saved {
+		if saved.Name == name {
+			config.Saved = append(config.Saved[:i], config.Saved[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Names of the parameters used, in alphabetical order
+func (saved SavedQuery) Params() []string {
+	seen := make(map[string]bool)
+	saved.Selector.MapStrings(func(s string) string {
+		for _, match := range paramRegex.FindAllStringSubmatch(s, -1) {
+			seen[match[1]] = true
+		}
+		return s
+	})
+	params := make([]string, 0, len(seen))
+	for param := range seen {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	return params
+}
+
+// Fills in parameters, failing if any of them is not given
+func (saved SavedQuery) Expand(params map[string]string) (common.QuerySelectors, error) {
+	missing := make([]string, 0)
+	for _, param := range saved.Params() {
+		if _, ok := params[param]; !ok {
+			missing = append(missing, param)
+		}
+	}
+	if len(missing) > 0 {
+		return saved.Selector, fmt.Errorf("Missing parameters for %s, use --param: %s", saved.Name, strings.Join(missing, ", "))
+	}
+	return saved.Selector.MapStrings(func(s string) string {
+		return paramRegex.ReplaceAllStringFunc(s, func(match string) string {
+			return params[paramRegex.FindStringSubmatch(match)[1]]
+		})
+	}), nil
+}
+
+func SavedQueryHintAction() []string {
+	config := LoadConfig()
+	results := make([]string, 0, len(config.Saved))
+	for _, saved := range config.Saved {
+		results = append(results, saved.Name)
+	}
+	return results
+}
+
+// Completes --param with the parameters of all saved queries
+func SavedQueryParamHintAction() []string {
+	config := LoadConfig()
+	results := make([]string, 0)
+	for _, saved := range config.Saved {
+		for _, param := range saved.Params() {
+			results = append(results, fmt.Sprintf("%s=", param))
+		}
+	}
+	return results
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+func TestExpandSavedQuery(t *testing.T) {
+	saved := SavedQuery{
+		Name: "errors",
+		Selector: common.QuerySelectors{
+			Where:       []string{"service={{service}}", "level=error"},
+			Last:        "{{ period }}",
+			QueryString: []string{"{{service}} timeout"},
+		},
+	}
+	if params := saved.Params(); !reflect.DeepEqual(params, []string{"period", "service"}) {
+		t.Errorf("Wrong params: %v", params)
+	}
+	if _, err := saved.Expand(map[string]string{"service": "api"}); err == nil {
+		t.Error("Expected error for missing period parameter")
+	}
+	expanded, err := saved.Expand(map[string]string{"service": "api", "period": "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expanded.Where[0] != "service=api" || expanded.Last != "1h" || expanded.QueryString[0] != "api timeout" {
+		t.Errorf("Wrong expansion: %+v", expanded)
+	}
+	if saved.Selector.Where[0] != "service={{service}}" {
+		t.Error("Expanding should not modify the saved query")
+	}
+}
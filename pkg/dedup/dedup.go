@@ -0,0 +1,163 @@
+package dedup
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+	"github.com/egnyte/ax/pkg/stats"
+)
+
+// Repeated occurrences of the same message
+type Group struct {
+	// The first message of the group
+	Message   common.LogMessage
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Count when the group was last reported, see Changed
+	reported int
+	key      string
+	// Position in the list of groups by last use, see Expire
+	element *list.Element
+}
+
+func (g *Group) Map() map[string]interface{} {
+	m := g.Message.Map()
+	m["@count"] = g.Count
+	m["@first_seen"] = g.FirstSeen
+	m["@last_seen"] = g.LastSeen
+	return m
+}
+
+// Groups messages by their content, or by the values of a set of attributes.
+// Messages only join a group when they arrive within window of the group's
+// last message, otherwise a new group is started (no limit if window is 0).
+type Deduplicator struct {
+	fields []string
+	window time.Duration
+	groups map[string]*Group
+	all    []*Group
+	// Groups from least to most recently added to
+	recent *list.List
+	// Number of expired groups still in all
+	expired int
+}
+
+func New(fields []string, window time.Duration) *Deduplicator {
+	return &Deduplicator{
+		fields: fields,
+		window: window,
+		groups: make(map[string]*Group),
+		all:    make([]*Group, 0, 100),
+		recent: list.New(),
+	}
+}
+
+func (d *Deduplicator) key(message common.LogMessage) string {
+	if len(d.fields) == 0 {
+		return message.ContentHash()
+	}
+	return strings.Join(stats.GroupValues(message, d.fields), "\x00")
+}
+
+// Adds a message, returning its group and whether it started a new one. New
+// groups count as reported, as they are usually shown right away.
+func (d *Deduplicator) Add(message common.LogMessage) (*Group, bool) {
+	key := d.key(message)
+	if group, ok := d.groups[key]; ok && (d.window == 0 || message.Timestamp.Sub(group.LastSeen) <= d.window) {
+		group.Count++
+		if message.Timestamp.Before(group.FirstSeen) {
+			group.FirstSeen = message.Timestamp
+		}
+		if message.Timestamp.After(group.LastSeen) {
+			group.LastSeen = message.Timestamp
+		}
+		d.recent.MoveToBack(group.element)
+		return group, false
+	}
+	group := &Group{
+		Message:   message,
+		Count:     1,
+		FirstSeen: message.Timestamp,
+		LastSeen:  message.Timestamp,
+		reported:  1,
+		key:       key,
+	}
+	group.element = d.recent.PushBack(group)
+	d.groups[key] = group
+	d.all = append(d.all, group)
+	return group, true
+}
+
+func (d *Deduplicator) remove(group *Group) {
+	d.recent.Remove(group.element)
+	group.element = nil
+	if d.groups[group.key] == group {
+		delete(d.groups, group.key)
+	}
+	d.expired++
+	if d.expired > len(d.all)/2 {
+		d.compact()
+	}
+}
+
+// Drops expired groups from the list of all groups
+func (d *Deduplicator) compact() {
+	current := make([]*Group, 0, len(d.all)-d.expired)
+	for _, group := range d.all {
+		if group.element != nil {
+			current = append(current, group)
+		}
+	}
+	d.all = current
+	d.expired = 0
+}
+
+// Forgets groups whose last message is more than the window before now (including
+// the ones a new group was started for), and
+// the least recently added to groups beyond maxGroups (no limit if 0), so
+// memory stays bounded when following. Returns the forgotten groups that got
+// new messages since they were last reported, and marks them as reported.
+func (d *Deduplicator) Expire(now time.Time, maxGroups int) []*Group {
+	changed := make([]*Group, 0)
+	for front := d.recent.Front(); front != nil; front = d.recent.Front() {
+		group := front.Value.(*Group)
+		outsideWindow := d.window > 0 && now.Sub(group.LastSeen) > d.window
+		if !outsideWindow && (maxGroups <= 0 || d.recent.Len() <= maxGroups) {
+			break
+		}
+		d.remove(group)
+		if group.Count > group.reported {
+			group.reported = group.Count
+			changed = append(changed, group)
+		}
+	}
+	return changed
+}
+
+// All groups that haven't expired in order of first appearance
+func (d *Deduplicator) Groups() []*Group {
+	if d.expired > 0 {
+		d.compact()
+	}
+	return d.all
+}
+
+// Returns the current groups that got new messages since they were last
+// reported, in order of first appearance, and marks them as reported
+func (d *Deduplicator) Changed() []*Group {
+	changed := make([]*Group, 0, 10)
+	for _, group := range d.groups {
+		if group.Count > group.reported {
+			group.reported = group.Count
+			changed = append(changed, group)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].FirstSeen.Before(changed[j].FirstSeen)
+	})
+	return changed
+}
@@ -0,0 +1,89 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+var start = time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)
+
+func message(offset time.Duration, text, host string) common.LogMessage {
+	return common.LogMessage{
+		Timestamp:  start.Add(offset),
+		Attributes: map[string]interface{}{"message": text, "host": host},
+	}
+}
+
+func TestDedupContent(t *testing.T) {
+	d := New(nil, 0)
+	d.Add(message(0, "Connection refused", "a"))
+	d.Add(message(time.Second, "Started", "a"))
+	group, isNew := d.Add(message(time.Minute, "Connection refused", "a"))
+	if isNew || group.Count != 2 {
+		t.Errorf("Expected repeated message to join group: %+v", group)
+	}
+	if !group.FirstSeen.Equal(start) || !group.LastSeen.Equal(start.Add(time.Minute)) {
+		t.Errorf("Wrong first/last seen: %s, %s", group.FirstSeen, group.LastSeen)
+	}
+	if _, isNew := d.Add(message(time.Minute, "Connection refused", "b")); !isNew {
+		t.Error("Expected message with different attributes to start a new group")
+	}
+	if groups := d.Groups(); len(groups) != 3 || groups[0].Count != 2 || groups[1].Count != 1 {
+		t.Errorf("Wrong groups: %+v", groups)
+	}
+}
+
+func TestDedupFieldsAndWindow(t *testing.T) {
+	d := New([]string{"host"}, time.Minute)
+	d.Add(message(0, "one", "a"))
+	d.Add(message(30*time.Second, "two", "a"))
+	if _, isNew := d.Add(message(5*time.Minute, "three", "a")); !isNew {
+		t.Error("Expected message outside of window to start a new group")
+	}
+	groups := d.Groups()
+	if len(groups) != 2 || groups[0].Count != 2 || groups[1].Count != 1 {
+		t.Errorf("Wrong groups: %+v", groups)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	d := New(nil, 0)
+	d.Add(message(0, "one", "a"))
+	d.Add(message(0, "two", "a"))
+	if changed := d.Changed(); len(changed) != 0 {
+		t.Errorf("New groups shouldn't count as changed: %+v", changed)
+	}
+	d.Add(message(time.Second, "two", "a"))
+	if changed := d.Changed(); len(changed) != 1 || changed[0].Count != 2 {
+		t.Errorf("Wrong changed groups: %+v", changed)
+	}
+	if changed := d.Changed(); len(changed) != 0 {
+		t.Errorf("Changed groups should be reported once: %+v", changed)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	d := New(nil, time.Minute)
+	d.Add(message(0, "one", "a"))
+	d.Add(message(10*time.Second, "two", "a"))
+	d.Add(message(20*time.Second, "one", "a"))
+	d.Add(message(30*time.Second, "three", "a"))
+	if expired := d.Expire(start.Add(75*time.Second), 0); len(expired) != 0 {
+		t.Errorf("Only groups with unreported repeats should be returned: %+v", expired)
+	}
+	if groups := d.Groups(); len(groups) != 2 || groups[0].Message.Attributes["message"] != "one" {
+		t.Errorf("Expected the group of two to be forgotten: %+v", groups)
+	}
+	expired := d.Expire(start.Add(81*time.Second), 1)
+	if len(expired) != 1 || expired[0].Count != 2 {
+		t.Errorf("Expected the repeated group to be reported on expiry: %+v", expired)
+	}
+	if groups := d.Groups(); len(groups) != 1 || groups[0].Message.Attributes["message"] != "three" {
+		t.Errorf("Wrong groups: %+v", groups)
+	}
+	if _, isNew := d.Add(message(90*time.Second, "one", "a")); !isNew {
+		t.Error("Expected an expired group to be started again")
+	}
+}
@@ -0,0 +1,176 @@
+// Package grok parses text with Logstash style grok patterns, such as
+// %{IPORHOST:clientip} %{NUMBER:bytes:int}, which are regular expressions
+// built from named, reusable patterns.
+package grok
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egnyte/ax/pkg/heuristic"
+)
+
+// Nesting limit, to catch patterns referring to themselves
+const maxDepth = 50
+
+var referenceRegex = regexp.MustCompile(`%\{(\w+)(?::([\w.@\[\]-]+))?(?::(int|float))?\}`)
+
+// A library of named patterns
+type Grok struct {
+	patterns map[string]string
+}
+
+type field struct {
+	name string
+	// Pattern matching the field, determines how the value is converted
+	pattern string
+	// Explicit conversion: int or float
+	conversion string
+}
+
+// A compiled pattern
+type Pattern struct {
+	regex  *regexp.Regexp
+	fields map[string]field
+}
+
+// Creates a library with the built-in patterns and the given ones, which take
+// precedence
+func New(patterns map[string]string) *Grok {
+	g := &Grok{patterns: make(map[string]string, len(builtinPatterns)+len(patterns))}
+	for name, pattern := range builtinPatterns {
+		g.patterns[name] = pattern
+	}
+	for name, pattern := range patterns {
+		g.patterns[name] = pattern
+	}
+	return g
+}
+
+// Compiles an expression like %{SYSLOGLINE}. A plain pattern name such as
+// COMBINEDAPACHELOG is accepted as well.
+func (g *Grok) Compile(expression string) (*Pattern, error) {
+	if _, ok := g.patterns[expression]; ok {
+		expression = fmt.Sprintf("%%{%s}", expression)
+	}
+	p := &Pattern{fields: make(map[string]field)}
+	expanded, err := g.expand(expression, p, 0)
+	if err != nil {
+		return nil, err
+	}
+	p.regex, err = regexp.Compile(expanded)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (g *Grok) expand(expression string, p *Pattern, depth int) (string, error) {
+	if depth > maxDepth {
+		return "", fmt.Errorf("Patterns nested too deeply: %s", expression)
+	}
+	var err error
+	expanded := referenceRegex.ReplaceAllStringFunc(expression, func(reference string) string {
+		if err != nil {
+			return ""
+		}
+		matches := referenceRegex.FindStringSubmatch(reference)
+		name, fieldName, conversion := matches[1], matches[2], matches[3]
+		pattern, ok := g.patterns[name]
+		if !ok {
+			err = fmt.Errorf("Unknown grok pattern: %s", name)
+			return ""
+		}
+		var inner string
+		inner, err = g.expand(pattern, p, depth+1)
+		if fieldName == "" {
+			return fmt.Sprintf("(?:%s)", inner)
+		}
+		// Field names may contain characters Go doesn't allow in group names
+		group := fmt.Sprintf("f%d", len(p.fields))
+		p.fields[group] = field{name: fieldName, pattern: name, conversion: conversion}
+		return fmt.Sprintf("(?P<%s>%s)", group, inner)
+	})
+	return expanded, err
+}
+
+// Returns the fields matched in text, converted to numbers and timestamps
+// (formatted as RFC3339) where possible, or false if the pattern doesn't match
+func (p *Pattern) Parse(text string) (map[string]interface{}, bool) {
+	matches := p.regex.FindStringSubmatch(text)
+	if matches == nil {
+		return nil, false
+	}
+	attributes := make(map[string]interface{})
+	for i, group := range p.regex.SubexpNames() {
+		f, ok := p.fields[group]
+		if !ok || matches[i] == "" {
+			continue
+		}
+		attributes[f.name] = f.convert(matches[i])
+	}
+	return attributes, true
+}
+
+// Parses a log line, keeping the whole line as the message unless the pattern
+// has a message field. Implements common.LineParser.
+func (p *Pattern) ParseLine(line string) (map[string]interface{}, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	attributes, ok := p.Parse(line)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := attributes["message"]; !ok {
+		attributes["message"] = line
+	}
+	return attributes, true
+}
+
+func (f field) convert(value string) interface{} {
+	switch {
+	case f.conversion == "int" || (f.conversion == "" && intPatterns[f.pattern]):
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case f.conversion == "float":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case numberPatterns[f.pattern]:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case timeLayouts[f.pattern] != nil:
+		if ts, ok := parseTime(f.pattern, value, time.Now()); ok {
+			return ts.Format(time.RFC3339Nano)
+		}
+	}
+	return value
+}
+
+func parseTime(pattern, value string, now time.Time) (time.Time, bool) {
+	if pattern == "TIMESTAMP_ISO8601" {
+		value = strings.Replace(value, ",", ".", 1)
+		if len(value) > 10 && value[10] == ' ' {
+			value = value[:10] + "T" + value[11:]
+		}
+	}
+	for _, layout := range timeLayouts[pattern] {
+		ts, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		if ts.Year() == 0 {
+			// Syslog timestamps have no year
+			ts = heuristic.InferYear(ts, now)
+		}
+		return ts, true
+	}
+	return time.Time{}, false
+}
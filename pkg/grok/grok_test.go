@@ -0,0 +1,114 @@
+package grok
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustCompile(g *Grok, expression string) *Pattern {
+	p, err := g.Compile(expression)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestCombinedApacheLog(t *testing.T) {
+	p := mustCompile(New(nil), "COMBINEDAPACHELOG")
+	attributes, ok := p.Parse(`127.0.0.1 - frank [10/Oct/2017:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`)
+	if !ok {
+		t.Fatal("Did not match")
+	}
+	expected := map[string]interface{}{
+		"clientip":    "127.0.0.1",
+		"ident":       "-",
+		"auth":        "frank",
+		"timestamp":   "2017-10-10T13:55:36-07:00",
+		"verb":        "GET",
+		"request":     "/apache_pb.gif",
+		"httpversion": 1.0,
+		"response":    int64(200),
+		"bytes":       int64(2326),
+		"referrer":    `"http://www.example.com/start.html"`,
+		"agent":       `"Mozilla/4.08"`,
+	}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("Wrong attributes: %+v", attributes)
+	}
+}
+
+func TestSyslogLine(t *testing.T) {
+	p := mustCompile(New(nil), "%{SYSLOGLINE}")
+	attributes, ok := p.ParseLine("Oct  3 08:12:01 web-1 sshd[4121]: Accepted publickey for deploy\n")
+	if !ok {
+		t.Fatal("Did not match")
+	}
+	ts, err := time.Parse(time.RFC3339Nano, attributes["timestamp"].(string))
+	if err != nil || ts.Month() != time.October || ts.Day() != 3 || ts.Year() < 2017 {
+		t.Errorf("Wrong timestamp: %v", attributes["timestamp"])
+	}
+	delete(attributes, "timestamp")
+	expected := map[string]interface{}{
+		"logsource": "web-1",
+		"program":   "sshd",
+		"pid":       int64(4121),
+		"message":   "Accepted publickey for deploy",
+	}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("Wrong attributes: %+v", attributes)
+	}
+}
+
+func TestUserPatterns(t *testing.T) {
+	g := New(map[string]string{
+		"DURATION": `%{NUMBER:duration:float}ms`,
+		"REQUEST":  `%{WORD:method} %{URIPATHPARAM:path} took %{DURATION}`,
+	})
+	p := mustCompile(g, "REQUEST")
+	attributes, ok := p.ParseLine("GET /api/users?id=5 took 12ms")
+	if !ok {
+		t.Fatal("Did not match")
+	}
+	expected := map[string]interface{}{
+		"method":   "GET",
+		"path":     "/api/users?id=5",
+		"duration": 12.0,
+		"message":  "GET /api/users?id=5 took 12ms",
+	}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("Wrong attributes: %+v", attributes)
+	}
+	if _, ok := p.ParseLine("Starting up"); ok {
+		t.Error("Should not have matched")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	g := New(map[string]string{"LOOP": "a%{LOOP}"})
+	for _, expression := range []string{"%{NOSUCHPATTERN}", "%{LOOP}", "%{INT:n} (unclosed"} {
+		if _, err := g.Compile(expression); err == nil {
+			t.Errorf("Should not have compiled: %s", expression)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		pattern  string
+		value    string
+		expected time.Time
+	}{
+		{"TIMESTAMP_ISO8601", "2017-10-17T10:00:00Z", time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)},
+		{"TIMESTAMP_ISO8601", "2017-10-17 10:00:00,500", time.Date(2017, 10, 17, 10, 0, 0, 500000000, time.Local)},
+		{"SYSLOGTIMESTAMP", "Jan  1 23:00:00", time.Date(2018, 1, 1, 23, 0, 0, 0, time.Local)},
+		{"SYSLOGTIMESTAMP", "Dec 31 23:00:00", time.Date(2017, 12, 31, 23, 0, 0, 0, time.Local)},
+	}
+	for _, test := range tests {
+		ts, ok := parseTime(test.pattern, test.value, now)
+		if !ok || !ts.Equal(test.expected) {
+			t.Errorf("Parsed %s as %v, expected %v", test.value, ts, test.expected)
+		}
+	}
+}
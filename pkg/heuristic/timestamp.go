@@ -33,6 +33,7 @@ var formatsToTry []string = []string{
 	time.RFC1123Z,
 	time.RFC3339Nano,
 	"2006-01-02 15:04:05,000",
+	time.Stamp,
 }
 
 var formatsToTryRx []*regexp.Regexp = []*regexp.Regexp{
@@ -59,6 +60,8 @@ var formatsToTryRx []*regexp.Regexp = []*regexp.Regexp{
 	regexp.MustCompile(`\d+-\d+-\d+[A-Za-z_]+\d+:\d+:\d+\.\d+[A-Za-z_]+\d+:\d+`),
 	// "2006-01-02 15:04:05",
 	regexp.MustCompile(`\d+-\d+-\d+ \d+:\d+:\d+(,\d+)?`),
+	// time.Stamp (syslog)
+	regexp.MustCompile(`[A-Z][a-z]{2} +\d+ \d+:\d+:\d+(\.\d+)?`),
 }
 
 func epochMsToTime(i int64) *time.Time {
@@ -158,9 +161,28 @@ func parseTime(format, s string) (time.Time, error) {
 		s = formatReplace.ReplaceAllString(s, "")
 		format = formatReplace.ReplaceAllString(format, "")
 	}
+	if format == time.Stamp {
+		// Without a zone, syslog timestamps are in local time (like in the syslog package)
+		ts, err := time.ParseInLocation(format, s, time.Local)
+		if err == nil {
+			ts = InferYear(ts, time.Now())
+		}
+		return ts, err
+	}
 	return time.Parse(format, s)
 }
 
+// Timestamps without a year (like syslog's Jan _2 15:04:05) are assumed to be
+// from the most recent year that doesn't put them in the future, allowing a day
+// of clock skew. So on January 1st, Dec 31 is from last year.
+func InferYear(ts time.Time, now time.Time) time.Time {
+	ts = ts.AddDate(now.Year()-ts.Year(), 0, 0)
+	if ts.After(now.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts
+}
+
 func findTimestampInMessage(exampleMessage common.LogMessage) LogTimestampParser {
 	//fmt.Println("Message", message)
 	message, _ := exampleMessage.Attributes["message"].(string)
@@ -2,6 +2,7 @@ package heuristic
 
 import (
 	"testing"
+	"time"
 
 	"github.com/egnyte/ax/pkg/backend/common"
 )
@@ -65,3 +66,46 @@ func TestFormatRegex(t *testing.T) {
 		}
 	}
 }
+
+func TestInferYear(t *testing.T) {
+	now := time.Date(2018, time.January, 2, 12, 0, 0, 0, time.UTC)
+	tests := map[string]int{
+		"Jan  2 11:00:00": 2018,
+		"Jan  3 11:00:00": 2018,
+		"Jan  4 11:00:00": 2017,
+		"Dec 31 23:59:59": 2017,
+	}
+	for s, year := range tests {
+		ts, err := time.Parse(time.Stamp, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inferred := InferYear(ts, now); inferred.Year() != year {
+			t.Errorf("Inferred %s as %v, expected year %d", s, inferred, year)
+		}
+	}
+}
+
+func TestParseSyslogTimestamp(t *testing.T) {
+	ts, err := ParseTimestamp("Oct  3 08:12:01")
+	if err != nil || ts == nil {
+		t.Fatal("Could not parse", err)
+	}
+	if ts.Year() < 2017 || ts.Month() != time.October || ts.Day() != 3 || ts.Hour() != 8 {
+		t.Errorf("Wrong timestamp: %v", ts)
+	}
+}
+
+func TestParseSyslogTimestampLocal(t *testing.T) {
+	local := time.Local
+	defer func() { time.Local = local }()
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	ts, err := ParseTimestamp("Oct  3 08:12:01")
+	if err != nil || ts == nil {
+		t.Fatal("Could not parse", err)
+	}
+	expected := time.Date(ts.Year(), time.October, 3, 8, 12, 1, 0, time.Local)
+	if !ts.Equal(expected) {
+		t.Errorf("Expected %v, got %v", expected, ts)
+	}
+}
@@ -0,0 +1,174 @@
+package patterns
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+// Placeholder for the variable parts of a message
+const Wildcard = "<*>"
+
+// Minimum fraction of tokens a message has to share with a pattern to be
+// considered an instance of it
+const similarityThreshold = 0.5
+
+// Maximum number of patterns per (token count, first token) group, to keep
+// matching fast for very diverse logs
+const maxClustersPerGroup = 100
+
+// A message template, e.g. "Connection to <*> timed out after <*> ms"
+type Pattern struct {
+	tokens  []string
+	Count   int
+	Example string
+}
+
+func (p *Pattern) Template() string {
+	return strings.Join(p.tokens, " ")
+}
+
+// The template, compressed and encoded so that it can be passed around as an
+// ID, e.g. in a saved query or a script, and be turned back into the template
+// without having to run ax patterns again
+func (p *Pattern) ID() string {
+	return TemplateID(p.Template())
+}
+
+func TemplateID(template string) string {
+	var buf bytes.Buffer
+	writer, _ := flate.NewWriter(&buf, flate.BestCompression)
+	writer.Write([]byte(template))
+	writer.Close()
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes())
+}
+
+// Turns an ID back into its template, see TemplateID
+func TemplateFromID(id string) (string, error) {
+	compressed, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return "", fmt.Errorf("Invalid pattern ID %s, use an ID shown by ax patterns", id)
+	}
+	template, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return "", fmt.Errorf("Invalid pattern ID %s, use an ID shown by ax patterns", id)
+	}
+	return string(template), nil
+}
+
+// Splits a message into tokens, replacing tokens containing digits (numbers,
+// IDs, IP addresses, timestamps and such) with a wildcard up front
+func tokenize(message string) []string {
+	tokens := strings.Fields(message)
+	for i, token := range tokens {
+		if strings.IndexFunc(token, unicode.IsDigit) != -1 {
+			tokens[i] = Wildcard
+		}
+	}
+	return tokens
+}
+
+// Fraction of tokens equal in both, and the number of wildcards in the pattern
+func similarity(pattern, tokens []string) (float64, int) {
+	equal := 0
+	wildcards := 0
+	for i, token := range pattern {
+		if token == Wildcard {
+			wildcards++
+		} else if token == tokens[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(tokens)), wildcards
+}
+
+// Clusters messages into patterns using the Drain algorithm (He et al., 2017):
+// messages are first grouped by their number of tokens and first token, then
+// assigned to the most similar pattern in that group. Tokens that differ
+// between a pattern and a new instance of it are replaced with a wildcard.
+type Miner struct {
+	groups   map[string][]*Pattern
+	patterns []*Pattern
+}
+
+func NewMiner() *Miner {
+	return &Miner{
+		groups:   make(map[string][]*Pattern),
+		patterns: make([]*Pattern, 0, 100),
+	}
+}
+
+func groupKey(tokens []string) string {
+	return fmt.Sprintf("%d %s", len(tokens), tokens[0])
+}
+
+// Adds a message, returning the pattern it was assigned to (nil for empty messages)
+func (miner *Miner) Add(message string) *Pattern {
+	tokens := tokenize(message)
+	if len(tokens) == 0 {
+		return nil
+	}
+	key := groupKey(tokens)
+	var best *Pattern
+	bestSimilarity := -1.0
+	bestWildcards := 0
+	for _, pattern := range miner.groups[key] {
+		sim, wildcards := similarity(pattern.tokens, tokens)
+		if sim > bestSimilarity || (sim == bestSimilarity && wildcards > bestWildcards) {
+			best, bestSimilarity, bestWildcards = pattern, sim, wildcards
+		}
+	}
+	if best != nil && (bestSimilarity >= similarityThreshold || len(miner.groups[key]) >= maxClustersPerGroup) {
+		for i, token := range best.tokens {
+			if token != tokens[i] {
+				best.tokens[i] = Wildcard
+			}
+		}
+		best.Count++
+		return best
+	}
+	pattern := &Pattern{
+		tokens:  tokens,
+		Count:   1,
+		Example: message,
+	}
+	miner.groups[key] = append(miner.groups[key], pattern)
+	miner.patterns = append(miner.patterns, pattern)
+	return pattern
+}
+
+// Patterns with the highest counts first, all of them if top is 0
+func (miner *Miner) Top(top int) []*Pattern {
+	patterns := append([]*Pattern{}, miner.patterns...)
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return patterns[i].Count > patterns[j].Count
+	})
+	if top > 0 && len(patterns) > top {
+		patterns = patterns[:top]
+	}
+	return patterns
+}
+
+// The message attribute patterns are mined from
+func MessageText(message common.LogMessage) (string, bool) {
+	text, ok := message.Attributes["message"].(string)
+	return text, ok
+}
+
+// Mines patterns from the message attribute of all messages from a channel
+func Mine(messages <-chan common.LogMessage) *Miner {
+	miner := NewMiner()
+	for message := range messages {
+		if text, ok := MessageText(message); ok {
+			miner.Add(text)
+		}
+	}
+	return miner
+}
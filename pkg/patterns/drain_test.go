@@ -0,0 +1,52 @@
+package patterns
+
+import (
+	"testing"
+)
+
+func TestMiner(t *testing.T) {
+	miner := NewMiner()
+	for _, message := range []string{
+		"Connection to db-1 timed out after 3000 ms",
+		"Connection to db-2 timed out after 500 ms",
+		"User alice logged in",
+		"Connection to cache timed out after 20 ms",
+		"User bob logged in",
+		"Shutting down",
+	} {
+		miner.Add(message)
+	}
+	patterns := miner.Top(2)
+	if len(patterns) != 2 {
+		t.Fatalf("Expected 2 patterns, got %d", len(patterns))
+	}
+	if template := patterns[0].Template(); template != "Connection to <*> timed out after <*> ms" || patterns[0].Count != 3 {
+		t.Errorf("Wrong top pattern: %s (%d)", template, patterns[0].Count)
+	}
+	if patterns[0].Example != "Connection to db-1 timed out after 3000 ms" {
+		t.Errorf("Wrong example: %s", patterns[0].Example)
+	}
+	if template := patterns[1].Template(); template != "User <*> logged in" || patterns[1].Count != 2 {
+		t.Errorf("Wrong second pattern: %s (%d)", template, patterns[1].Count)
+	}
+	id := patterns[0].ID()
+	if template, err := TemplateFromID(id); err != nil || template != "Connection to <*> timed out after <*> ms" {
+		t.Errorf("Pattern ID %s doesn't resolve to its template: %s (%v)", id, template, err)
+	}
+	if _, err := TemplateFromID("3f2a9c1b"); err == nil {
+		t.Error("Expected an error for an invalid ID")
+	}
+}
+
+func TestMatchesTemplate(t *testing.T) {
+	template := "Connection to <*> timed out after <*> ms"
+	if !MatchesTemplate(template, "Connection to db-7 timed out after 12 ms") {
+		t.Error("Expected message to match template")
+	}
+	if MatchesTemplate(template, "Connection to db-7 timed out") {
+		t.Error("Expected shorter message not to match template")
+	}
+	if MatchesTemplate(template, "Connection to db-7 failed after 12 ms") {
+		t.Error("Expected different message not to match template")
+	}
+}
@@ -0,0 +1,60 @@
+package patterns
+
+import (
+	"strings"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+// Whether a message is an instance of a template
+func MatchesTemplate(template, message string) bool {
+	templateTokens := strings.Fields(template)
+	tokens := tokenize(message)
+	if len(templateTokens) != len(tokens) {
+		return false
+	}
+	for i, token := range templateTokens {
+		if token != Wildcard && token != tokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Keeps messages matching any of the include templates (if any) and none of
+// the exclude templates
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+func (f Filter) Matches(message common.LogMessage) bool {
+	text, _ := MessageText(message)
+	for _, template := range f.Exclude {
+		if MatchesTemplate(template, text) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, template := range f.Include {
+		if MatchesTemplate(template, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) FilterMessages(messages <-chan common.LogMessage) <-chan common.LogMessage {
+	resultChan := make(chan common.LogMessage)
+	go func() {
+		for message := range messages {
+			if f.Matches(message) {
+				resultChan <- message
+			}
+		}
+		close(resultChan)
+	}()
+	return resultChan
+}
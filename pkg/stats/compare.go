@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	StatusNew     = "new"
+	StatusGone    = "gone"
+	StatusChanged = "changed"
+)
+
+// Minimum number of messages in either window for a change in frequency to be
+// considered significant, so that a few messages more or less don't count
+const minChangeCount = 5
+
+// Difference in frequency of a pattern or group between two time windows
+type Change struct {
+	Key      string  `json:"key"`
+	Status   string  `json:"status"`
+	Baseline int     `json:"baseline"`
+	Current  int     `json:"current"`
+	Factor   float64 `json:"factor,omitempty"`
+}
+
+func rate(count int, d time.Duration) float64 {
+	return float64(count) / d.Seconds()
+}
+
+// Compares counts per key between a baseline and a current window, normalized
+// by the durations of the windows. Reports keys that only appear in one of
+// them, and those whose frequency changed by at least the threshold factor.
+func Compare(baseline, current map[string]int, baselineDuration, currentDuration time.Duration, threshold float64) []Change {
+	changes := make([]Change, 0, 20)
+	for key, count := range current {
+		if baseline[key] == 0 {
+			changes = append(changes, Change{Key: key, Status: StatusNew, Current: count})
+		}
+	}
+	for key, count := range baseline {
+		if current[key] == 0 {
+			changes = append(changes, Change{Key: key, Status: StatusGone, Baseline: count})
+			continue
+		}
+		if count < minChangeCount && current[key] < minChangeCount {
+			continue
+		}
+		factor := rate(current[key], currentDuration) / rate(count, baselineDuration)
+		if factor >= threshold || factor <= 1/threshold {
+			changes = append(changes, Change{Key: key, Status: StatusChanged, Baseline: count, Current: current[key], Factor: factor})
+		}
+	}
+	order := map[string]int{StatusNew: 0, StatusChanged: 1, StatusGone: 2}
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Status != b.Status {
+			return order[a.Status] < order[b.Status]
+		}
+		switch a.Status {
+		case StatusNew:
+			if a.Current != b.Current {
+				return a.Current > b.Current
+			}
+		case StatusGone:
+			if a.Baseline != b.Baseline {
+				return a.Baseline > b.Baseline
+			}
+		case StatusChanged:
+			// Biggest changes first, whether up or down
+			if fa, fb := math.Abs(math.Log(a.Factor)), math.Abs(math.Log(b.Factor)); fa != fb {
+				return fa > fb
+			}
+		}
+		return a.Key < b.Key
+	})
+	return changes
+}
@@ -0,0 +1,23 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	baseline := map[string]int{"steady": 100, "spiking": 10, "dropping": 40, "gone": 3, "rare": 2}
+	current := map[string]int{"steady": 55, "spiking": 60, "dropping": 5, "new": 7, "rare": 1}
+	// The current window is half as long as the baseline
+	changes := Compare(baseline, current, 2*time.Hour, time.Hour, 2)
+	expected := []Change{
+		{Key: "new", Status: StatusNew, Current: 7},
+		{Key: "spiking", Status: StatusChanged, Baseline: 10, Current: 60, Factor: 12},
+		{Key: "dropping", Status: StatusChanged, Baseline: 40, Current: 5, Factor: 0.25},
+		{Key: "gone", Status: StatusGone, Baseline: 3},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Wrong changes: %+v", changes)
+	}
+}
@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"strings"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+// Buckets messages by their timestamp. When interval is 0 one is picked based on
+// the time span of the messages, so messages are counted per second first.
+// Fails when the interval results in more than common.MaxHistogramBuckets.
+func Histogram(messages <-chan common.LogMessage, interval time.Duration) ([]common.TimeBucket, error) {
+	resolution := interval
+	if resolution == 0 {
+		resolution = time.Second
+	}
+	counts := make(map[int64]int)
+	var from, to time.Time
+	for message := range messages {
+		start := message.Timestamp.Truncate(resolution)
+		counts[start.UnixNano()]++
+		if from.IsZero() || start.Before(from) {
+			from = start
+		}
+		if to.IsZero() || start.After(to) {
+			to = start
+		}
+	}
+	if len(counts) == 0 {
+		return []common.TimeBucket{}, nil
+	}
+	if interval == 0 {
+		interval = common.HistogramInterval(to.Sub(from))
+	}
+	buckets, err := common.HistogramBuckets(from, to, interval)
+	if err != nil {
+		return nil, err
+	}
+	first := buckets[0].Start
+	for ts, count := range counts {
+		i := int(time.Unix(0, ts).Sub(first) / interval)
+		buckets[i].Count += count
+	}
+	return buckets, nil
+}
+
+var partialBlocks = []string{"", "▏", "▎", "▍", "▌", "▋", "▊", "▉"}
+
+// Renders a horizontal bar of at most width characters, using eighth blocks
+// for the remainder so small differences remain visible
+func Bar(value, max, width int) string {
+	if max <= 0 || value <= 0 {
+		return ""
+	}
+	eighths := value * width * 8 / max
+	if eighths == 0 {
+		// Never hide non-zero values completely
+		eighths = 1
+	}
+	return strings.Repeat("█", eighths/8) + partialBlocks[eighths%8]
+}
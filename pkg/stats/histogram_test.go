@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+func histogramTestMessages(offsets ...time.Duration) <-chan common.LogMessage {
+	start := time.Date(2017, 10, 17, 10, 0, 0, 0, time.UTC)
+	messages := make(chan common.LogMessage)
+	go func() {
+		for _, offset := range offsets {
+			messages <- common.LogMessage{Timestamp: start.Add(offset)}
+		}
+		close(messages)
+	}()
+	return messages
+}
+
+func TestHistogram(t *testing.T) {
+	buckets, err := Histogram(histogramTestMessages(10*time.Second, 50*time.Second, 3*time.Minute+5*time.Second), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{2, 0, 0, 1}
+	if len(buckets) != len(expected) {
+		t.Fatalf("Expected %d buckets, got %+v", len(expected), buckets)
+	}
+	for i, bucket := range buckets {
+		if bucket.Count != expected[i] {
+			t.Errorf("Bucket %d: expected %d, got %d", i, expected[i], bucket.Count)
+		}
+		if minute := bucket.Start.Minute(); minute != i {
+			t.Errorf("Bucket %d starts at wrong time: %s", i, bucket.Start)
+		}
+	}
+}
+
+func TestHistogramAutoInterval(t *testing.T) {
+	buckets, err := Histogram(histogramTestMessages(0, 30*time.Minute, 2*time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 25 {
+		t.Fatalf("Expected 5m buckets, got %d", len(buckets))
+	}
+	if buckets[0].Count != 1 || buckets[6].Count != 1 || buckets[24].Count != 1 {
+		t.Errorf("Wrong counts: %+v", buckets)
+	}
+}
+
+func TestHistogramTooManyBuckets(t *testing.T) {
+	if buckets, err := Histogram(histogramTestMessages(0, 30*24*time.Hour), time.Second); err == nil {
+		t.Errorf("Expected an error, got %d buckets", len(buckets))
+	}
+}
+
+func TestBar(t *testing.T) {
+	for _, test := range []struct {
+		value, max, width int
+		expected          string
+	}{
+		{10, 10, 4, "████"},
+		{5, 10, 4, "██"},
+		{3, 10, 4, "█▏"},
+		{1, 1000, 4, "▏"},
+		{0, 10, 4, ""},
+	} {
+		if bar := Bar(test.value, test.max, test.width); bar != test.expected {
+			t.Errorf("Bar(%d, %d, %d) = %q, expected %q", test.value, test.max, test.width, bar, test.expected)
+		}
+	}
+}
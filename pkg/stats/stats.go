@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+// Counts messages grouped by the values of a list of attributes, used for
+// backends that can't do so server side
+type Counter struct {
+	fields []string
+	groups map[string]*common.GroupCount
+	mutex  sync.Mutex
+}
+
+func NewCounter(fields []string) *Counter {
+	return &Counter{
+		fields: fields,
+		groups: make(map[string]*common.GroupCount),
+	}
+}
+
+// Values of the given attributes as strings, MissingValue for attributes a message doesn't have
+func GroupValues(message common.LogMessage, fields []string) []string {
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		if value, ok := message.Attributes[field]; ok && value != nil {
+			values[i] = fmt.Sprintf("%v", value)
+		} else {
+			values[i] = common.MissingValue
+		}
+	}
+	return values
+}
+
+func (counter *Counter) Add(message common.LogMessage) {
+	values := GroupValues(message, counter.fields)
+	key := strings.Join(values, "\x00")
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	if group, ok := counter.groups[key]; ok {
+		group.Count++
+	} else {
+		counter.groups[key] = &common.GroupCount{Values: values, Count: 1}
+	}
+}
+
+// Returns the top groups with the highest counts (all of them if top is 0)
+func (counter *Counter) Top(top int) []common.GroupCount {
+	counter.mutex.Lock()
+	groups := make([]common.GroupCount, 0, len(counter.groups))
+	for _, group := range counter.groups {
+		groups = append(groups, *group)
+	}
+	counter.mutex.Unlock()
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count == groups[j].Count {
+			return strings.Join(groups[i].Values, "\x00") < strings.Join(groups[j].Values, "\x00")
+		}
+		return groups[i].Count > groups[j].Count
+	})
+	if top > 0 && len(groups) > top {
+		groups = groups[:top]
+	}
+	return groups
+}
+
+// Counts all messages from a channel
+func CountBy(messages <-chan common.LogMessage, fields []string, top int) []common.GroupCount {
+	counter := NewCounter(fields)
+	for message := range messages {
+		counter.Add(message)
+	}
+	return counter.Top(top)
+}
@@ -0,0 +1,34 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/egnyte/ax/pkg/backend/common"
+)
+
+func TestCountBy(t *testing.T) {
+	messages := make(chan common.LogMessage)
+	go func() {
+		for _, attributes := range []map[string]interface{}{
+			{"service": "api", "level": "error"},
+			{"service": "api", "level": "error"},
+			{"service": "api", "level": "info"},
+			{"service": "web", "level": "error"},
+			{"service": "web", "level": "error"},
+			{"service": "web", "level": "error"},
+			{"level": "warn"},
+		} {
+			messages <- common.LogMessage{Attributes: attributes}
+		}
+		close(messages)
+	}()
+	expected := []common.GroupCount{
+		{Values: []string{"web", "error"}, Count: 3},
+		{Values: []string{"api", "error"}, Count: 2},
+		{Values: []string{common.MissingValue, "warn"}, Count: 1},
+	}
+	if groups := CountBy(messages, []string{"service", "level"}, 3); !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Wrong counts: %+v", groups)
+	}
+}
@@ -0,0 +1,182 @@
+// Package syslog parses syslog lines in the RFC 5424 format and the older BSD
+// (RFC 3164) format used by files such as /var/log/syslog.
+package syslog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egnyte/ax/pkg/heuristic"
+)
+
+var (
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	rfc5424Regex = regexp.MustCompile(`^<(\d{1,3})>(\d{1,2}) (\S+) (\S+) (\S+) (\S+) (\S+) (.*)$`)
+	// <PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG, where the priority is left out
+	// in files. Some daemons log RFC3339 timestamps instead.
+	rfc3164Regex = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]?\d \d{2}:\d{2}:\d{2}(?:\.\d+)?|\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\S*) (\S+) (?:([^\s\[\]:]+)(?:\[([^\]]*)\])?: ?)?(.*)$`)
+)
+
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Implements common.LineParser
+type Parser struct {
+	// Also accept RFC 3164 lines without a priority that start with an RFC3339
+	// timestamp. Plenty of application logs look like that too, so this is
+	// only for when the input is known to be syslog.
+	AllowRFC3339 bool
+}
+
+func (p Parser) ParseLine(line string) (map[string]interface{}, bool) {
+	return parse(line, time.Now(), p.AllowRFC3339)
+}
+
+// Parses a syslog line into attributes: priority, facility, severity,
+// timestamp (as RFC3339), hostname, app_name, procid, msgid, message and for
+// RFC 5424 the structured data as <SD-ID>.<PARAM-NAME>. Returns false for
+// lines in neither format, and for RFC 3164 lines that have neither a priority
+// nor a Mmm dd hh:mm:ss timestamp. Years of RFC 3164 timestamps are inferred
+// from now.
+func Parse(line string, now time.Time) (map[string]interface{}, bool) {
+	return parse(line, now, false)
+}
+
+func parse(line string, now time.Time, allowRFC3339 bool) (map[string]interface{}, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if matches := rfc5424Regex.FindStringSubmatch(line); matches != nil {
+		return parse5424(matches)
+	}
+	if matches := rfc3164Regex.FindStringSubmatch(line); matches != nil {
+		return parse3164(matches, now, allowRFC3339)
+	}
+	return nil, false
+}
+
+func parse5424(matches []string) (map[string]interface{}, bool) {
+	attributes := make(map[string]interface{})
+	if !addPriority(attributes, matches[1]) {
+		return nil, false
+	}
+	if matches[3] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, matches[3])
+		if err != nil {
+			return nil, false
+		}
+		attributes["timestamp"] = ts.Format(time.RFC3339Nano)
+	}
+	for i, name := range []string{"hostname", "app_name", "procid", "msgid"} {
+		if value := matches[4+i]; value != "-" {
+			attributes[name] = value
+		}
+	}
+	// Structured data may contain spaces, so it is split from the message by hand
+	rest := matches[8]
+	if rest == "-" || strings.HasPrefix(rest, "- ") {
+		rest = rest[1:]
+	} else {
+		var ok bool
+		rest, ok = parseStructuredData(rest, attributes)
+		if !ok {
+			return nil, false
+		}
+	}
+	attributes["message"] = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	return attributes, true
+}
+
+// Parses [id name="value" ...] elements at the start of s into attributes,
+// returning what follows them
+func parseStructuredData(s string, attributes map[string]interface{}) (string, bool) {
+	for strings.HasPrefix(s, "[") {
+		end := strings.IndexAny(s, " ]")
+		if end < 0 {
+			return "", false
+		}
+		id := s[1:end]
+		s = s[end:]
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			separator := strings.Index(s, `="`)
+			if separator <= 0 {
+				return "", false
+			}
+			name := s[:separator]
+			value, rest, ok := readParamValue(s[separator+2:])
+			if !ok {
+				return "", false
+			}
+			attributes[id+"."+name] = value
+			s = rest
+		}
+		if !strings.HasPrefix(s, "]") {
+			return "", false
+		}
+		s = s[1:]
+	}
+	return s, true
+}
+
+// Reads a parameter value up to its closing quote, in which \", \\ and \] are escaped
+func readParamValue(s string) (string, string, bool) {
+	var value strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+				i++
+			}
+			value.WriteByte(s[i])
+		case '"':
+			return value.String(), s[i+1:], true
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", "", false
+}
+
+func parse3164(matches []string, now time.Time, allowRFC3339 bool) (map[string]interface{}, bool) {
+	attributes := make(map[string]interface{})
+	if matches[1] != "" && !addPriority(attributes, matches[1]) {
+		return nil, false
+	}
+	ts, err := time.ParseInLocation(time.Stamp, matches[2], time.Local)
+	if err == nil {
+		ts = heuristic.InferYear(ts, now)
+	} else if matches[1] == "" && !allowRFC3339 {
+		// Without a priority, "<ISO timestamp> INFO ..." isn't syslog
+		return nil, false
+	} else if ts, err = time.Parse(time.RFC3339Nano, matches[2]); err != nil {
+		return nil, false
+	}
+	attributes["timestamp"] = ts.Format(time.RFC3339Nano)
+	attributes["hostname"] = matches[3]
+	if matches[4] != "" {
+		attributes["app_name"] = matches[4]
+	}
+	if matches[5] != "" {
+		attributes["procid"] = matches[5]
+	}
+	attributes["message"] = matches[6]
+	return attributes, true
+}
+
+// Adds the priority and the facility and severity names it encodes
+func addPriority(attributes map[string]interface{}, pri string) bool {
+	priority, err := strconv.Atoi(pri)
+	if err != nil || priority > 191 {
+		return false
+	}
+	attributes["priority"] = priority
+	attributes["facility"] = facilities[priority/8]
+	attributes["severity"] = severities[priority%8]
+	return true
+}
@@ -0,0 +1,108 @@
+package syslog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse5424(t *testing.T) {
+	line := `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high \"x\" \]"] An application event log entry...`
+	attributes, ok := Parse(line, time.Now())
+	if !ok {
+		t.Fatal("Did not parse")
+	}
+	expected := map[string]interface{}{
+		"priority":                      165,
+		"facility":                      "local4",
+		"severity":                      "notice",
+		"timestamp":                     "2003-10-11T22:14:15.003Z",
+		"hostname":                      "mymachine.example.com",
+		"app_name":                      "evntslog",
+		"msgid":                         "ID47",
+		"exampleSDID@32473.iut":         "3",
+		"exampleSDID@32473.eventSource": "Application",
+		"exampleSDID@32473.eventID":     "1011",
+		"examplePriority@32473.class":   `high "x" ]`,
+		"message":                       "An application event log entry...",
+	}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("Wrong attributes: %+v", attributes)
+	}
+
+	attributes, ok = Parse("<34>1 - host su 123 - -", time.Now())
+	expected = map[string]interface{}{
+		"priority": 34,
+		"facility": "auth",
+		"severity": "crit",
+		"hostname": "host",
+		"app_name": "su",
+		"procid":   "123",
+		"message":  "",
+	}
+	if !ok || !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("Wrong attributes: %+v", attributes)
+	}
+}
+
+func TestParse3164(t *testing.T) {
+	now := time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)
+	attributes, ok := Parse("<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8", now)
+	expected := map[string]interface{}{
+		"priority":  34,
+		"facility":  "auth",
+		"severity":  "crit",
+		"timestamp": time.Date(2017, time.October, 11, 22, 14, 15, 0, time.Local).Format(time.RFC3339Nano),
+		"hostname":  "mymachine",
+		"app_name":  "su",
+		"message":   "'su root' failed for lonvick on /dev/pts/8",
+	}
+	if !ok || !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("Wrong attributes: %+v", attributes)
+	}
+
+	attributes, ok = Parse("Jan  1 08:12:01 web-1 sshd[4121]: Accepted publickey for deploy\n", now)
+	expected = map[string]interface{}{
+		"timestamp": time.Date(2018, time.January, 1, 8, 12, 1, 0, time.Local).Format(time.RFC3339Nano),
+		"hostname":  "web-1",
+		"app_name":  "sshd",
+		"procid":    "4121",
+		"message":   "Accepted publickey for deploy",
+	}
+	if !ok || !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("Wrong attributes: %+v", attributes)
+	}
+
+	attributes, ok = Parse("<6>2017-10-17T10:00:00.5+02:00 web-1 kernel: [  1.0] eth0 up", now)
+	if !ok || attributes["timestamp"] != "2017-10-17T10:00:00.5+02:00" || attributes["app_name"] != "kernel" || attributes["message"] != "[  1.0] eth0 up" {
+		t.Errorf("Wrong attributes: %+v", attributes)
+	}
+
+	// Without a priority only when asked to parse syslog explicitly
+	line := "2017-10-17T10:00:00.5+02:00 web-1 kernel: [  1.0] eth0 up"
+	if attributes, ok := Parse(line, now); ok {
+		t.Errorf("Should not have parsed %q: %+v", line, attributes)
+	}
+	attributes, ok = Parser{AllowRFC3339: true}.ParseLine(line)
+	if !ok || attributes["timestamp"] != "2017-10-17T10:00:00.5+02:00" || attributes["app_name"] != "kernel" || attributes["message"] != "[  1.0] eth0 up" {
+		t.Errorf("Wrong attributes: %+v", attributes)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"Connection refused",
+		"level=info msg=hi",
+		`{"message": "json"}`,
+		"<999>1 2003-10-11T22:14:15.003Z host app - - - message",
+		"<34>1 2003-10-11T22:14:15.003Z host app - - [unterminated x=\"1\" message",
+		"<34>1 yesterday host app - - - message",
+		"2017-10-17T10:00:00Z INFO Started server",
+		"2017-10-17T10:00:00+02:00 ERROR db: connection lost",
+	} {
+		if attributes, ok := Parse(line, time.Now()); ok {
+			t.Errorf("Should not have parsed %q: %+v", line, attributes)
+		}
+	}
+}
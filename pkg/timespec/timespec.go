@@ -0,0 +1,130 @@
+package timespec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+)
+
+var durationUnits = map[string]time.Duration{
+	"ms":      time.Millisecond,
+	"s":       time.Second,
+	"sec":     time.Second,
+	"secs":    time.Second,
+	"second":  time.Second,
+	"seconds": time.Second,
+	"m":       time.Minute,
+	"min":     time.Minute,
+	"mins":    time.Minute,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"h":       time.Hour,
+	"hr":      time.Hour,
+	"hrs":     time.Hour,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+	"d":       24 * time.Hour,
+	"day":     24 * time.Hour,
+	"days":    24 * time.Hour,
+	"w":       7 * 24 * time.Hour,
+	"week":    7 * 24 * time.Hour,
+	"weeks":   7 * 24 * time.Hour,
+}
+
+var durationPartRegex = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*([a-zA-Z]+)`)
+
+// Like time.ParseDuration, but also supports days and weeks as well as spelled out units
+// and spaces, e.g. "1d12h", "90m" or "2 hours"
+func ParseDuration(s string) (time.Duration, error) {
+	var total time.Duration
+	rest := strings.TrimSpace(s)
+	if rest == "" {
+		return 0, fmt.Errorf("Invalid duration: %q", s)
+	}
+	for rest != "" {
+		matches := durationPartRegex.FindStringSubmatch(rest)
+		if matches == nil {
+			return 0, fmt.Errorf("Invalid duration: %q", s)
+		}
+		unit, ok := durationUnits[strings.ToLower(matches[2])]
+		if !ok {
+			return 0, fmt.Errorf("Invalid duration unit %q in %q", matches[2], s)
+		}
+		n, err := strconv.ParseFloat(matches[1], 64)
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(n * float64(unit))
+		rest = strings.TrimSpace(rest[len(matches[0]):])
+	}
+	return total, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Parses an absolute or relative time expression relative to now. Supported are
+// "now", "today", "yesterday", durations meaning that long ago ("15m", "2h ago",
+// "3 days ago") and any absolute date format understood by dateparse.
+func Parse(expr string, now time.Time) (time.Time, error) {
+	s := strings.ToLower(strings.TrimSpace(expr))
+	switch s {
+	case "now":
+		return now, nil
+	case "today":
+		return startOfDay(now), nil
+	case "yesterday":
+		return startOfDay(now).AddDate(0, 0, -1), nil
+	}
+	if d, err := ParseDuration(strings.TrimSuffix(s, " ago")); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := dateparse.ParseIn(strings.TrimSpace(expr), now.Location())
+	if err != nil {
+		return t, fmt.Errorf("Could not parse time %q", expr)
+	}
+	return t, nil
+}
+
+var aroundSeparatorRegex = regexp.MustCompile(`\s*(±|\+-|\+/-)\s*`)
+
+// Parses an expression such as "2017-10-17T10:00 ±5m" (or "+-5m") into the
+// time range around the given time
+func ParseAround(expr string, now time.Time) (after time.Time, before time.Time, err error) {
+	pieces := aroundSeparatorRegex.Split(strings.TrimSpace(expr), 2)
+	if len(pieces) != 2 {
+		return after, before, fmt.Errorf("Invalid time range %q, expected e.g. \"2017-10-17T10:00 ±5m\"", expr)
+	}
+	t, err := Parse(pieces[0], now)
+	if err != nil {
+		return after, before, err
+	}
+	d, err := ParseDuration(pieces[1])
+	if err != nil {
+		return after, before, err
+	}
+	return t.Add(-d), t.Add(d), nil
+}
+
+// Parses a time range such as "2h ago..1h ago" or "2017-10-17 10:00..now"
+func ParseRange(expr string, now time.Time) (from time.Time, to time.Time, err error) {
+	pieces := strings.SplitN(expr, "..", 2)
+	if len(pieces) != 2 {
+		return from, to, fmt.Errorf("Invalid time range %q, expected e.g. \"2h ago..1h ago\"", expr)
+	}
+	if from, err = Parse(pieces[0], now); err != nil {
+		return from, to, err
+	}
+	if to, err = Parse(pieces[1], now); err != nil {
+		return from, to, err
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("Invalid time range %q, it ends before it starts", expr)
+	}
+	return from, to, nil
+}
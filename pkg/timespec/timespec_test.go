@@ -0,0 +1,89 @@
+package timespec
+
+import (
+	"testing"
+	"time"
+)
+
+var now = time.Date(2017, 10, 17, 14, 30, 0, 0, time.UTC)
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"15m":       15 * time.Minute,
+		"1d12h":     36 * time.Hour,
+		"2 hours":   2 * time.Hour,
+		"1w":        7 * 24 * time.Hour,
+		"1.5h":      90 * time.Minute,
+		"3 days 2m": 72*time.Hour + 2*time.Minute,
+	}
+	for input, expected := range tests {
+		d, err := ParseDuration(input)
+		if err != nil {
+			t.Errorf("Could not parse %q: %v", input, err)
+		} else if d != expected {
+			t.Errorf("Parsed %q as %s, expected %s", input, d, expected)
+		}
+	}
+	for _, input := range []string{"", "15", "m", "5 lightyears", "2017-10-17"} {
+		if _, err := ParseDuration(input); err == nil {
+			t.Errorf("Should not have parsed %q", input)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]time.Time{
+		"now":              now,
+		"15m":              now.Add(-15 * time.Minute),
+		"2h ago":           now.Add(-2 * time.Hour),
+		"3 days ago":       now.Add(-72 * time.Hour),
+		"today":            time.Date(2017, 10, 17, 0, 0, 0, 0, time.UTC),
+		"Yesterday":        time.Date(2017, 10, 16, 0, 0, 0, 0, time.UTC),
+		"2017-10-01 10:00": time.Date(2017, 10, 1, 10, 0, 0, 0, time.UTC),
+	}
+	for input, expected := range tests {
+		ts, err := Parse(input, now)
+		if err != nil {
+			t.Errorf("Could not parse %q: %v", input, err)
+		} else if !ts.Equal(expected) {
+			t.Errorf("Parsed %q as %s, expected %s", input, ts, expected)
+		}
+	}
+	if _, err := Parse("sometime soon", now); err == nil {
+		t.Error("Should not have parsed")
+	}
+}
+
+func TestParseAround(t *testing.T) {
+	for _, input := range []string{"2017-10-17T10:00 ±5m", "2017-10-17T10:00 +-5m", "2017-10-17T10:00+-5m"} {
+		after, before, err := ParseAround(input, now)
+		if err != nil {
+			t.Errorf("Could not parse %q: %v", input, err)
+			continue
+		}
+		if !after.Equal(time.Date(2017, 10, 17, 9, 55, 0, 0, time.UTC)) || !before.Equal(time.Date(2017, 10, 17, 10, 5, 0, 0, time.UTC)) {
+			t.Errorf("Wrong range for %q: %s - %s", input, after, before)
+		}
+	}
+	if _, _, err := ParseAround("2017-10-17T10:00", now); err == nil {
+		t.Error("Should not have parsed without a duration")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	from, to, err := ParseRange("2h ago..1h ago", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(now.Add(-2*time.Hour)) || !to.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Wrong range: %s - %s", from, to)
+	}
+	if _, to, err := ParseRange("2017-10-17 09:00..now", now); err != nil || !to.Equal(now) {
+		t.Errorf("Wrong range end %s: %v", to, err)
+	}
+	for _, input := range []string{"2h ago", "1h ago..2h ago", "2h ago..soon"} {
+		if _, _, err := ParseRange(input, now); err == nil {
+			t.Errorf("Should not have parsed %q", input)
+		}
+	}
+}